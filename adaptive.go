@@ -0,0 +1,207 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAdaptiveMin      = 1
+	defaultAdaptiveMax      = 100
+	defaultAdaptiveDecrease = 0.5
+)
+
+// AdaptiveConcurrencyConfig configures an AdaptiveLimiter, zero values are
+// replaced by defaults.
+type AdaptiveConcurrencyConfig struct {
+	// Initial is the starting limit, defaults to DefaultMaxConcurrency.
+	Initial int
+	// Min is the lower bound of the limit, defaults to 1.
+	Min int
+	// Max is the upper bound of the limit, defaults to 100.
+	Max int
+	// LatencyThreshold is the request latency above which the limit is cut
+	// as for a 429 response. Zero only cuts the limit on 429 and 503
+	// responses.
+	LatencyThreshold time.Duration
+	// Decrease is the factor the limit is multiplied by when cut, defaults
+	// to 0.5.
+	Decrease float64
+	// OnLimitChange is called with the new limit every time it changes. It
+	// is called with the limiter locked and must not block.
+	OnLimitChange func(limit int)
+}
+
+// AdaptiveLimiter bounds the number of requests of batch and streaming sends
+// in flight with an AIMD algorithm: the limit grows by one for every limit
+// successful requests, and is cut by a factor when FCM answers 429 or 503,
+// or any error classified as ActionRetryLater, or a request is too slow.
+// Only requests started after the last cut can cut it again, so a burst of
+// failures of the same requests cuts it once. Every attempt of a send with
+// retries is limited on its own, the backoff between them doesn't hold a
+// slot.
+//
+// An AdaptiveLimiter is safe for concurrent use.
+type AdaptiveLimiter struct {
+	mu       sync.Mutex
+	cfg      AdaptiveConcurrencyConfig
+	limit    float64
+	inFlight int
+	cutAt    time.Time
+	wake     chan struct{}
+	now      func() time.Time
+}
+
+// NewAdaptiveLimiter creates an AdaptiveLimiter starting at cfg.Initial.
+func NewAdaptiveLimiter(cfg AdaptiveConcurrencyConfig) *AdaptiveLimiter {
+	if cfg.Min <= 0 {
+		cfg.Min = defaultAdaptiveMin
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = defaultAdaptiveMax
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.Initial <= 0 {
+		cfg.Initial = DefaultMaxConcurrency
+	}
+	if cfg.Initial < cfg.Min {
+		cfg.Initial = cfg.Min
+	}
+	if cfg.Initial > cfg.Max {
+		cfg.Initial = cfg.Max
+	}
+	if cfg.Decrease <= 0 || cfg.Decrease >= 1 {
+		cfg.Decrease = defaultAdaptiveDecrease
+	}
+	return &AdaptiveLimiter{
+		cfg:   cfg,
+		limit: float64(cfg.Initial),
+		wake:  make(chan struct{}),
+		now:   time.Now,
+	}
+}
+
+// Limit returns the current number of sends allowed in flight.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of sends in flight.
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// acquire blocks until a send may start or ctx is done, and returns the
+// start time to pass to release.
+func (l *AdaptiveLimiter) acquire(ctx context.Context) (time.Time, error) {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			start := l.now()
+			l.mu.Unlock()
+			return start, nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// release ends a send started at start and adjusts the limit from its
+// outcome.
+func (l *AdaptiveLimiter) release(start time.Time, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	now := l.now()
+	prev := int(l.limit)
+	switch {
+	case isOverloaded(err) || (l.cfg.LatencyThreshold > 0 && now.Sub(start) > l.cfg.LatencyThreshold):
+		if start.Before(l.cutAt) {
+			break
+		}
+		l.limit *= l.cfg.Decrease
+		if l.limit < float64(l.cfg.Min) {
+			l.limit = float64(l.cfg.Min)
+		}
+		l.cutAt = now
+	case err == nil:
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.cfg.Max) {
+			l.limit = float64(l.cfg.Max)
+		}
+	}
+	if limit := int(l.limit); limit != prev && l.cfg.OnLimitChange != nil {
+		l.cfg.OnLimitChange(limit)
+	}
+
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// abandon ends a send which was not made, leaving the limit as is.
+func (l *AdaptiveLimiter) abandon() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// isOverloaded reports whether err tells that FCM is throttling the sender
+// or overloaded: the errors classified as ActionRetryLater, and the 503
+// responses which came without a retry delay.
+func isOverloaded(err error) bool {
+	if Classify(err) == ActionRetryLater {
+		return true
+	}
+	var fcmErr *Error
+	return errors.As(err, &fcmErr) &&
+		(fcmErr.HTTPStatus == http.StatusServiceUnavailable || fcmErr.Code == ErrorCodeUnavailable)
+}
+
+// maxConcurrency returns the number of workers of batch and streaming sends.
+func (c *Client) maxConcurrency() int {
+	if c.adaptive != nil {
+		return c.adaptive.cfg.Max
+	}
+	return c.concurrency
+}
+
+type batchSendKey struct{}
+
+// batchSend marks the context of a batch or streaming send: each of its
+// attempts goes through the adaptive limiter, if configured. It records
+// whether an attempt reached the server.
+type batchSend struct {
+	started bool
+}
+
+// withBatchSend returns a context marking a batch or streaming send.
+func withBatchSend(ctx context.Context) (context.Context, *batchSend) {
+	bs := new(batchSend)
+	return context.WithValue(ctx, batchSendKey{}, bs), bs
+}
+
+// batchSendFromContext returns the batchSend of ctx, nil if there is none.
+func batchSendFromContext(ctx context.Context) *batchSend {
+	bs, _ := ctx.Value(batchSendKey{}).(*batchSend)
+	return bs
+}
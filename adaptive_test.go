@@ -0,0 +1,271 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	overloaded := &Error{HTTPStatus: http.StatusTooManyRequests, Code: ErrorCodeQuotaExceeded}
+
+	t.Run("defaults", func(t *testing.T) {
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{Initial: 500})
+		if l.Limit() != defaultAdaptiveMax {
+			t.Fatalf("expected initial limit capped to %d, got: %d", defaultAdaptiveMax, l.Limit())
+		}
+	})
+
+	t.Run("increase", func(t *testing.T) {
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{Initial: 2, Max: 3})
+		for i := 0; i < 3; i++ {
+			start, err := l.acquire(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			l.release(start, nil)
+		}
+		if l.Limit() != 3 {
+			t.Fatalf("expected limit of 3 after 3 successes, got: %d", l.Limit())
+		}
+		for i := 0; i < 10; i++ {
+			start, _ := l.acquire(context.Background())
+			l.release(start, nil)
+		}
+		if l.Limit() != 3 {
+			t.Fatalf("expected limit capped to 3, got: %d", l.Limit())
+		}
+	})
+
+	t.Run("decrease", func(t *testing.T) {
+		var changes []int
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{
+			Initial:       8,
+			Min:           3,
+			OnLimitChange: func(limit int) { changes = append(changes, limit) },
+		})
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		l.now = func() time.Time { return now }
+
+		a, _ := l.acquire(context.Background())
+		b, _ := l.acquire(context.Background())
+		now = now.Add(time.Millisecond)
+		l.release(a, overloaded)
+		l.release(b, overloaded)
+		if l.Limit() != 4 {
+			t.Fatalf("expected a single cut to 4 for sends in flight together, got: %d", l.Limit())
+		}
+
+		now = now.Add(time.Millisecond)
+		c, _ := l.acquire(context.Background())
+		l.release(c, &Error{HTTPStatus: http.StatusServiceUnavailable})
+		if l.Limit() != 3 {
+			t.Fatalf("expected limit cut to the min of 3, got: %d", l.Limit())
+		}
+
+		d, _ := l.acquire(context.Background())
+		l.release(d, ErrInvalidArgument)
+		if l.Limit() != 3 || l.InFlight() != 0 {
+			t.Fatalf("expected rejected messages not to change the limit, got: %d, %d in flight", l.Limit(), l.InFlight())
+		}
+		if fmt.Sprint(changes) != "[4 3]" {
+			t.Fatalf("unexpected limit changes: %v", changes)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{Initial: 10, LatencyThreshold: time.Second})
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		l.now = func() time.Time { return now }
+
+		start, _ := l.acquire(context.Background())
+		now = now.Add(2 * time.Second)
+		l.release(start, nil)
+		if l.Limit() != 5 {
+			t.Fatalf("expected slow send to cut the limit to 5, got: %d", l.Limit())
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{Initial: 1, Max: 1})
+		start, err := l.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context error, got: %v", err)
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			l.release(start, nil)
+		}()
+		if _, err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		var inFlight, maxInFlight, requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			if atomic.AddInt32(&requests, 1) <= 4 {
+				rw.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(rw, `{"error": {"code": 429, "status": "RESOURCE_EXHAUSTED"}}`)
+				return
+			}
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		defer server.Close()
+
+		if _, err := NewClient("test", WithAdaptiveConcurrency(nil)); err == nil {
+			t.Fatal("expected error due to nil adaptive limiter, got nil")
+		}
+		minLimit := int32(4)
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{
+			Initial: 4,
+			Max:     8,
+			OnLimitChange: func(limit int) {
+				if int32(limit) < minLimit {
+					minLimit = int32(limit)
+				}
+			},
+		})
+		client, err := NewClient("test", WithEndpoint(server.URL), WithAdaptiveConcurrency(l))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		msgs := make([]*NewMessage, 40)
+		for i := range msgs {
+			msgs[i] = &NewMessage{Message{Topic: "news"}}
+		}
+		br, err := client.SendEach(context.Background(), "token", msgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if br.SuccessCount != 36 || br.FailureCount != 4 {
+			t.Fatalf("unexpected results: %+v", br)
+		}
+		if m := atomic.LoadInt32(&maxInFlight); m > 8 {
+			t.Fatalf("expected at most 8 sends in flight, got: %d", m)
+		}
+		if l.InFlight() != 0 {
+			t.Fatalf("expected no sends in flight, got: %d", l.InFlight())
+		}
+		if minLimit >= 4 {
+			t.Fatalf("expected limit cut below 4 by the 429 responses, got: %d", minLimit)
+		}
+	})
+
+	t.Run("overloaded", func(t *testing.T) {
+		for _, tt := range []struct {
+			name     string
+			err      error
+			expected bool
+		}{
+			{"quota", overloaded, true},
+			{"unavailable", &Error{HTTPStatus: http.StatusServiceUnavailable, Code: ErrorCodeUnavailable}, true},
+			{"retry after", &Error{HTTPStatus: http.StatusInternalServerError, Code: ErrorCodeUnavailable, RetryAfter: time.Second}, true},
+			{"circuit open", ErrCircuitOpen, true},
+			{"internal", &Error{HTTPStatus: http.StatusInternalServerError, Code: ErrorCodeInternal}, false},
+			{"unregistered", &Error{HTTPStatus: http.StatusNotFound, Code: ErrorCodeUnregistered}, false},
+			{"canceled", context.Canceled, false},
+			{"nil", nil, false},
+		} {
+			if isOverloaded(tt.err) != tt.expected {
+				t.Fatalf("%s: expected %v, got: %v", tt.name, tt.expected, !tt.expected)
+			}
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			// The request context is only canceled once the body was read.
+			ioutil.ReadAll(req.Body)
+			started <- struct{}{}
+			<-req.Context().Done()
+		}))
+		defer server.Close()
+
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{Initial: 1, Max: 4})
+		client, err := NewClient("test", WithEndpoint(server.URL), WithAdaptiveConcurrency(l))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs := make([]*NewMessage, 4)
+		for i := range msgs {
+			msgs[i] = &NewMessage{Message{Topic: "news"}}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		br, err := client.SendEach(ctx, "token", msgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if br.FailureCount != 4 || br.CanceledCount != 3 {
+			t.Fatalf("expected the sends waiting for the limiter to be canceled, got: %+v", br)
+		}
+		if l.InFlight() != 0 {
+			t.Fatalf("expected no sends in flight, got: %d", l.InFlight())
+		}
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		defer server.Close()
+
+		l := NewAdaptiveLimiter(AdaptiveConcurrencyConfig{Initial: 1, Max: 1})
+		var inFlight []int
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithAdaptiveConcurrency(l),
+			WithOnRetry(func(RetryEvent) {
+				inFlight = append(inFlight, l.InFlight())
+			}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sender := &Sender{Client: client, AccessToken: "token", RetryAttempts: 2}
+		in := make(chan SendRequest, 1)
+		in <- SendRequest{Message: &NewMessage{Message{Topic: "news"}}}
+		close(in)
+		for res := range sender.Run(context.Background(), in) {
+			if res.Err != nil {
+				t.Fatalf("unexpected error: %v", res.Err)
+			}
+		}
+		if len(inFlight) != 1 || inFlight[0] != 0 {
+			t.Fatalf("expected the limiter to be released during the backoff, got: %v", inFlight)
+		}
+	})
+}
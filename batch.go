@@ -0,0 +1,138 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultMaxConcurrency is the number of messages sent in parallel by the
+// batch send methods.
+const DefaultMaxConcurrency = 10
+
+// ErrEmptyBatch occurs if a batch send is called without any message.
+var ErrEmptyBatch = errors.New("no messages to send")
+
+type (
+	// SendResponse is the result of sending a single message of a batch.
+	SendResponse struct {
+		// Response is the server response, nil if the send failed.
+		Response *Response
+		// Err is the error of the send, nil on success.
+		Err error
+		// Canceled reports whether the message was not sent because the
+		// context was done before its turn came. Err holds the context error.
+		Canceled bool
+	}
+
+	// BatchResponse holds the results of a batch send, in the order of the
+	// messages.
+	BatchResponse struct {
+		Responses    []SendResponse
+		SuccessCount int
+		// FailureCount is the number of messages which were not sent,
+		// including the canceled ones.
+		FailureCount  int
+		CanceledCount int
+	}
+)
+
+// Success reports whether the message was sent.
+func (r SendResponse) Success() bool {
+	return r.Err == nil
+}
+
+// SendEach sends every message on its own, with at most the configured
+// number of sends in flight, see WithMaxConcurrency and
+// WithAdaptiveConcurrency. Each send is bounded by the client timeout.
+//
+// The returned BatchResponse holds the result of each message at its index.
+// Once ctx is done, the messages which were not sent yet are marked as
+// canceled. A non-nil error is only returned if msgs is empty.
+func (c *Client) SendEach(ctx context.Context, accessToken string, msgs []*NewMessage) (*BatchResponse, error) {
+	if len(msgs) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	return c.sendBatch(ctx, len(msgs), func(ctx context.Context, i int) (*Response, error) {
+		return c.sendOne(ctx, accessToken, msgs[i])
+	}), nil
+}
+
+// sendBatch sends the indexes [0, n) with send, see sendEach, and gathers
+// the results.
+func (c *Client) sendBatch(ctx context.Context, n int, send func(context.Context, int) (*Response, error)) *BatchResponse {
+	br := &BatchResponse{Responses: make([]SendResponse, n)}
+	c.sendEach(ctx, n, func(ctx context.Context, i int) {
+		sendCtx, bs := withBatchSend(ctx)
+		resp, err := send(sendCtx, i)
+		if err != nil && !bs.started && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			// The context was done while the send waited for its turn.
+			br.Responses[i] = SendResponse{Err: ctx.Err(), Canceled: true}
+			return
+		}
+		br.Responses[i] = SendResponse{Response: resp, Err: err}
+	}, func(i int, err error) {
+		br.Responses[i] = SendResponse{Err: err, Canceled: true}
+	})
+
+	for _, r := range br.Responses {
+		switch {
+		case r.Success():
+			br.SuccessCount++
+		case r.Canceled:
+			br.CanceledCount++
+			br.FailureCount++
+		default:
+			br.FailureCount++
+		}
+	}
+	return br
+}
+
+// sendOne sends a message bounded by the client timeout.
+func (c *Client) sendOne(ctx context.Context, accessToken string, msg *NewMessage) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.SendWithContext(ctx, accessToken, msg)
+}
+
+// sendEach calls send for the indexes [0, n) from a bounded pool of workers.
+// Once ctx is done, cancel is called for the indexes not sent yet.
+func (c *Client) sendEach(ctx context.Context, n int, send func(context.Context, int), cancel func(int, error)) {
+	workers := c.maxConcurrency()
+	if workers > n {
+		workers = n
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					cancel(i, err)
+					continue
+				}
+				send(ctx, i)
+			}
+		}()
+	}
+
+	i := 0
+dispatch:
+	for ; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	for ; i < n; i++ {
+		cancel(i, ctx.Err())
+	}
+	wg.Wait()
+}
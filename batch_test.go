@@ -0,0 +1,148 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendEach(t *testing.T) {
+	t.Run("send_each=results", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			var msg NewMessage
+			if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			if msg.Message.Token == "bad" {
+				rw.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(rw, `{"error": {"code": 404, "status": "NOT_FOUND", "details": [
+					{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}
+				]}}`)
+				return
+			}
+			fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL), WithMaxConcurrency(3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tokens := []string{"a", "bad", "c", "d", "bad", "f", "g"}
+		msgs := make([]*NewMessage, len(tokens))
+		for i, token := range tokens {
+			msgs[i] = &NewMessage{Message{Token: token}}
+		}
+		msgs = append(msgs, &NewMessage{})
+
+		br, err := client.SendEach(context.Background(), "token", msgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if br.SuccessCount != 5 || br.FailureCount != 3 || br.CanceledCount != 0 {
+			t.Fatalf("unexpected counts: %+v", br)
+		}
+		for i, token := range tokens {
+			r := br.Responses[i]
+			if token == "bad" {
+				if !errors.Is(r.Err, ErrUnregistered) {
+					t.Errorf("%d: expected <%v> error, got: %v", i, ErrUnregistered, r.Err)
+				}
+				continue
+			}
+			if !r.Success() || r.Response.Name != "projects/test/messages/"+token {
+				t.Errorf("%d: unexpected response: %+v", i, r)
+			}
+		}
+		if !errors.Is(br.Responses[7].Err, ErrInvalidTarget) {
+			t.Errorf("expected invalid message error, got: %v", br.Responses[7].Err)
+		}
+	})
+
+	t.Run("send_each=concurrency", func(t *testing.T) {
+		var inFlight, maxInFlight int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL), WithMaxConcurrency(4))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs := make([]*NewMessage, 20)
+		for i := range msgs {
+			msgs[i] = &NewMessage{Message{Topic: "news"}}
+		}
+		br, err := client.SendEach(context.Background(), "token", msgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if br.SuccessCount != 20 {
+			t.Fatalf("expected 20 successes, got: %+v", br)
+		}
+		if max := atomic.LoadInt32(&maxInFlight); max > 4 {
+			t.Fatalf("expected at most 4 sends in flight, got: %d", max)
+		}
+	})
+
+	t.Run("send_each=canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var once sync.Once
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			once.Do(cancel)
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL), WithMaxConcurrency(1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs := make([]*NewMessage, 10)
+		for i := range msgs {
+			msgs[i] = &NewMessage{Message{Topic: "news"}}
+		}
+		br, err := client.SendEach(ctx, "token", msgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if br.CanceledCount < 8 || br.SuccessCount+br.FailureCount != 10 {
+			t.Fatalf("expected unsent messages to be canceled, got: %+v", br)
+		}
+		last := br.Responses[9]
+		if !last.Canceled || !errors.Is(last.Err, context.Canceled) {
+			t.Fatalf("expected last message to be canceled, got: %+v", last)
+		}
+	})
+
+	t.Run("send_each=empty", func(t *testing.T) {
+		client, err := NewClient("test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := client.SendEach(context.Background(), "token", nil); !errors.Is(err, ErrEmptyBatch) {
+			t.Fatalf("expected <%v> error, got: %v", ErrEmptyBatch, err)
+		}
+		if _, err := NewClient("test", WithMaxConcurrency(0)); err == nil {
+			t.Fatal("expected error due to invalid concurrency, got nil")
+		}
+	})
+}
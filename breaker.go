@@ -0,0 +1,262 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen occurs if a send is rejected because the circuit breaker is
+// open after sustained failures of the FCM endpoint.
+var ErrCircuitOpen = errors.New("fcm circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+// Circuit breaker states.
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through to
+	// check whether the endpoint recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	defaultBreakerWindow       = 30 * time.Second
+	defaultBreakerFailureRatio = 0.5
+	defaultBreakerMinRequests  = 20
+	defaultBreakerOpenTimeout  = 10 * time.Second
+	defaultBreakerProbes       = 1
+
+	// breakerBuckets is the number of buckets the sliding window is split in.
+	breakerBuckets = 10
+
+	// minBreakerWindow is the smallest sliding window, a millisecond per
+	// bucket.
+	minBreakerWindow = breakerBuckets * time.Millisecond
+)
+
+// CircuitBreakerConfig configures a CircuitBreaker, zero values are replaced
+// by defaults.
+type CircuitBreakerConfig struct {
+	// Window is the duration of the sliding window the error rate is
+	// computed over, defaults to 30s. Windows under 10ms are raised to 10ms.
+	Window time.Duration
+	// FailureRatio is the ratio of failed requests in the window which opens
+	// the circuit, defaults to 0.5.
+	FailureRatio float64
+	// MinRequests is the number of requests in the window below which the
+	// circuit never opens, defaults to 20.
+	MinRequests int
+	// OpenTimeout is how long the circuit stays open before probing the
+	// endpoint again, defaults to 10s.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of probe requests let through while half
+	// open, all of them must succeed to close the circuit. Defaults to 1.
+	HalfOpenProbes int
+	// OnStateChange is called on every state transition. It is called with
+	// the breaker locked and must not block.
+	OnStateChange func(from, to CircuitState)
+}
+
+// CircuitBreaker fast-fails sends with ErrCircuitOpen while the FCM endpoint
+// returns sustained 5xx, connection or timeout errors. Rejected messages,
+// e.g. invalid tokens or exceeded quotas, don't count against the endpoint.
+//
+// A CircuitBreaker is safe for concurrent use.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	cfg       CircuitBreakerConfig
+	state     CircuitState
+	openedAt  time.Time
+	probes    int
+	successes int
+	// generation changes on every transition, so that the outcome of a
+	// request admitted in a previous state is ignored.
+	generation uint64
+	buckets    [breakerBuckets]breakerBucket
+	now        func() time.Time
+}
+
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker.
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultBreakerWindow
+	}
+	if cfg.Window < minBreakerWindow {
+		cfg.Window = minBreakerWindow
+	}
+	if cfg.FailureRatio <= 0 || cfg.FailureRatio > 1 {
+		cfg.FailureRatio = defaultBreakerFailureRatio
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultBreakerMinRequests
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = defaultBreakerProbes
+	}
+	return &CircuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(b.now())
+	return b.state
+}
+
+// allow reports whether a request may be sent, and returns the generation
+// to record its outcome with.
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(b.now())
+	switch b.state {
+	case CircuitOpen:
+		return 0, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probes >= b.cfg.HalfOpenProbes {
+			return 0, ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// record records the outcome of a request let through by allow under the
+// given generation.
+func (b *CircuitBreaker) record(generation uint64, err error) {
+	failure := isEndpointFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The state changed since the request was let through, e.g. a request
+	// admitted while closed must not count as a half open probe.
+	if generation != b.generation {
+		return
+	}
+
+	// A request canceled by the caller says nothing about the endpoint.
+	if errors.Is(err, context.Canceled) {
+		if b.state == CircuitHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		return
+	}
+
+	now := b.now()
+	switch b.state {
+	case CircuitHalfOpen:
+		if failure {
+			b.open(now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenProbes {
+			b.reset()
+			b.transition(CircuitClosed)
+		}
+	case CircuitClosed:
+		bucket := b.bucket(now)
+		bucket.requests++
+		if failure {
+			bucket.failures++
+		}
+		requests, failures := b.totals(now)
+		if requests >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRatio*float64(requests) {
+			b.open(now)
+		}
+	}
+}
+
+// refresh moves an open breaker to half open once OpenTimeout elapsed.
+func (b *CircuitBreaker) refresh(now time.Time) {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.reset()
+		b.transition(CircuitHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.openedAt = now
+	b.reset()
+	b.transition(CircuitOpen)
+}
+
+func (b *CircuitBreaker) transition(to CircuitState) {
+	from := b.state
+	b.state = to
+	if from == to {
+		return
+	}
+	b.generation++
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to)
+	}
+}
+
+// bucket returns the bucket of the sliding window now falls in.
+func (b *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := b.cfg.Window / breakerBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// totals sums the buckets within the window.
+func (b *CircuitBreaker) totals(now time.Time) (requests, failures int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+func (b *CircuitBreaker) reset() {
+	b.buckets = [breakerBuckets]breakerBucket{}
+	b.probes = 0
+	b.successes = 0
+}
+
+// isEndpointFailure reports whether err means the endpoint itself failed,
+// i.e. a 5xx response, a connection error or a timeout.
+func isEndpointFailure(err error) bool {
+	var fcmErr *Error
+	if errors.As(err, &fcmErr) {
+		return fcmErr.Temporary()
+	}
+	return Classify(err) == ActionRetry
+}
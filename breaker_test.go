@@ -0,0 +1,209 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	newBreaker := func(transitions *[]CircuitState) (*CircuitBreaker, *time.Time) {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		b := NewCircuitBreaker(CircuitBreakerConfig{
+			Window:         10 * time.Second,
+			FailureRatio:   0.5,
+			MinRequests:    4,
+			OpenTimeout:    5 * time.Second,
+			HalfOpenProbes: 2,
+			OnStateChange: func(from, to CircuitState) {
+				*transitions = append(*transitions, to)
+			},
+		})
+		b.now = func() time.Time { return now }
+		return b, &now
+	}
+	serverErr := &Error{HTTPStatus: http.StatusServiceUnavailable}
+
+	t.Run("open and recover", func(t *testing.T) {
+		var transitions []CircuitState
+		b, now := newBreaker(&transitions)
+
+		for i := 0; i < 3; i++ {
+			gen, err := b.allow()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b.record(gen, serverErr)
+		}
+		if b.State() != CircuitClosed {
+			t.Fatal("expected breaker to stay closed below MinRequests")
+		}
+		b.record(0, nil)
+		if b.State() != CircuitOpen {
+			t.Fatalf("expected breaker to open, got: %v", b.State())
+		}
+		if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected <%v> error, got: %v", ErrCircuitOpen, err)
+		}
+
+		*now = now.Add(5 * time.Second)
+		if b.State() != CircuitHalfOpen {
+			t.Fatalf("expected breaker to be half open, got: %v", b.State())
+		}
+		gen1, err1 := b.allow()
+		gen2, err2 := b.allow()
+		if err1 != nil || err2 != nil {
+			t.Fatal("expected probes to be allowed")
+		}
+		if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected probes to be limited, got: %v", err)
+		}
+		b.record(gen1, nil)
+		b.record(gen2, nil)
+		if b.State() != CircuitClosed {
+			t.Fatalf("expected breaker to close, got: %v", b.State())
+		}
+
+		expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}
+		if len(transitions) != len(expected) {
+			t.Fatalf("expected transitions %v, got: %v", expected, transitions)
+		}
+		for i := range expected {
+			if transitions[i] != expected[i] {
+				t.Fatalf("expected transitions %v, got: %v", expected, transitions)
+			}
+		}
+	})
+
+	t.Run("failed probe", func(t *testing.T) {
+		var transitions []CircuitState
+		b, now := newBreaker(&transitions)
+		for i := 0; i < 4; i++ {
+			b.record(0, connectionError{errors.New("refused")})
+		}
+		*now = now.Add(5 * time.Second)
+		gen, err := b.allow()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b.record(gen, serverErr)
+		if b.State() != CircuitOpen {
+			t.Fatalf("expected breaker to reopen, got: %v", b.State())
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		var transitions []CircuitState
+		b, now := newBreaker(&transitions)
+		b.record(0, serverErr)
+		b.record(0, serverErr)
+		*now = now.Add(11 * time.Second)
+		b.record(0, serverErr)
+		b.record(0, nil)
+		if b.State() != CircuitClosed {
+			t.Fatal("expected failures outside the window to be ignored")
+		}
+	})
+
+	t.Run("client errors", func(t *testing.T) {
+		var transitions []CircuitState
+		b, _ := newBreaker(&transitions)
+		for i := 0; i < 10; i++ {
+			b.record(0, &Error{HTTPStatus: http.StatusNotFound, Code: ErrorCodeUnregistered})
+			b.record(0, &Error{HTTPStatus: http.StatusTooManyRequests, Code: ErrorCodeQuotaExceeded})
+			b.record(0, context.Canceled)
+		}
+		if b.State() != CircuitClosed {
+			t.Fatal("expected rejected messages not to open the breaker")
+		}
+	})
+
+	t.Run("stale outcome", func(t *testing.T) {
+		var transitions []CircuitState
+		b, now := newBreaker(&transitions)
+		closedGen, _ := b.allow()
+		for i := 0; i < 4; i++ {
+			b.record(closedGen, serverErr)
+		}
+		*now = now.Add(5 * time.Second)
+		if b.State() != CircuitHalfOpen {
+			t.Fatalf("expected breaker to be half open, got: %v", b.State())
+		}
+		b.record(closedGen, nil)
+		b.record(closedGen, nil)
+		if b.State() != CircuitHalfOpen {
+			t.Fatalf("expected requests admitted while closed not to count as probes, got: %v", b.State())
+		}
+	})
+
+	t.Run("small window", func(t *testing.T) {
+		b := NewCircuitBreaker(CircuitBreakerConfig{Window: 5})
+		b.record(0, serverErr)
+		if b.State() != CircuitClosed {
+			t.Fatalf("unexpected state: %v", b.State())
+		}
+	})
+
+	t.Run("canceled sends", func(t *testing.T) {
+		started := make(chan struct{}, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			// The request context is only canceled once the body was read.
+			ioutil.ReadAll(req.Body)
+			started <- struct{}{}
+			<-req.Context().Done()
+		}))
+		defer server.Close()
+
+		breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 2})
+		client, err := NewClient("test", WithEndpoint(server.URL), WithCircuitBreaker(breaker), WithMaxConcurrency(5))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs := make([]*NewMessage, 10)
+		for i := range msgs {
+			msgs[i] = &NewMessage{Message{Topic: "test"}}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for i := 0; i < 5; i++ {
+				<-started
+			}
+			cancel()
+		}()
+		if _, err := client.SendEach(ctx, "token", msgs); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if breaker.State() != CircuitClosed {
+			t.Fatalf("expected canceled sends not to open the breaker, got: %v", breaker.State())
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requests++
+			rw.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 2, OpenTimeout: time.Hour})
+		client, err := NewClient("test", WithEndpoint(server.URL), WithCircuitBreaker(breaker))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = client.SendWithRetry(&NewMessage{Message{Topic: "test"}}, "token", 5)
+		if !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected <%v> error, got: %v", ErrCircuitOpen, err)
+		}
+		if requests != 2 {
+			t.Fatalf("expected 2 requests before the breaker opened, got: %d", requests)
+		}
+		if Classify(err) != ActionRetryLater {
+			t.Fatalf("expected %v, got: %v", ActionRetryLater, Classify(err))
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package fcm
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RetryBudget limits the retries of a Client to a fraction of its successful
+// requests, so that retries are shed instead of multiplying the load while
+// FCM is failing.
+//
+// The budget is a token bucket: every successful request deposits ratio
+// tokens, every retry withdraws one token and is denied if less than one
+// token is left. The balance is capped at, and starts with, maxTokens so
+// that a few retries are possible before any request succeeded.
+//
+// A RetryBudget is safe for concurrent use and may be shared by clients.
+type RetryBudget struct {
+	mu        sync.Mutex
+	ratio     float64
+	maxTokens float64
+	tokens    float64
+	stats     RetryBudgetStats
+}
+
+// RetryBudgetStats holds the counters of a RetryBudget.
+type RetryBudgetStats struct {
+	// Balance is the number of tokens currently available.
+	Balance float64
+	// Deposits is the number of successful requests recorded.
+	Deposits uint64
+	// Allowed is the number of retries allowed by the budget.
+	Allowed uint64
+	// Denied is the number of retries denied by the budget.
+	Denied uint64
+}
+
+// NewRetryBudget creates a RetryBudget allowing ratio retries per successful
+// request, e.g. 0.1 allows one retry every ten successful requests, with at
+// most maxTokens retries in a row.
+func NewRetryBudget(ratio float64, maxTokens int) (*RetryBudget, error) {
+	if ratio <= 0 {
+		return nil, errors.New("invalid retry budget ratio")
+	}
+	if maxTokens < 1 {
+		return nil, errors.New("invalid retry budget size")
+	}
+	return &RetryBudget{
+		ratio:     ratio,
+		maxTokens: float64(maxTokens),
+		tokens:    float64(maxTokens),
+	}, nil
+}
+
+// Stats returns a snapshot of the budget counters.
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Balance = b.tokens
+	return stats
+}
+
+// deposit records a successful request.
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	b.stats.Deposits++
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+	b.mu.Unlock()
+}
+
+// withdraw reports whether a retry is allowed and consumes a token if so.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		b.stats.Denied++
+		return false
+	}
+	b.tokens--
+	b.stats.Allowed++
+	return true
+}
+
+// refund gives back the token of a retry which was allowed but not made.
+func (b *RetryBudget) refund() {
+	b.mu.Lock()
+	b.stats.Allowed--
+	b.tokens++
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+	b.mu.Unlock()
+}
+
+// budgetPolicy denies the retries of policy once the budget is exhausted.
+type budgetPolicy struct {
+	policy RetryPolicy
+	budget *RetryBudget
+}
+
+func (p budgetPolicy) Next(state RetryState) (time.Duration, bool) {
+	return p.policy.Next(state)
+}
+
+// admit withdraws a token for a retry which is about to be made.
+func (p budgetPolicy) admit() bool {
+	return p.budget.withdraw()
+}
+
+// refund gives back the token of a retry abandoned during its backoff.
+func (p budgetPolicy) refund() {
+	p.budget.refund()
+}
@@ -0,0 +1,131 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewRetryBudget(0, 10); err == nil {
+			t.Fatal("expected error for zero ratio")
+		}
+		if _, err := NewRetryBudget(0.1, 0); err == nil {
+			t.Fatal("expected error for empty budget")
+		}
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		b, err := NewRetryBudget(0.5, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !b.withdraw() || !b.withdraw() {
+			t.Fatal("expected initial tokens to allow 2 retries")
+		}
+		if b.withdraw() {
+			t.Fatal("expected exhausted budget to deny retry")
+		}
+		b.deposit()
+		if b.withdraw() {
+			t.Fatal("expected half a token to deny retry")
+		}
+		b.deposit()
+		if !b.withdraw() {
+			t.Fatal("expected two deposits to allow a retry")
+		}
+		for i := 0; i < 10; i++ {
+			b.deposit()
+		}
+
+		stats := b.Stats()
+		if stats.Balance != 2 {
+			t.Errorf("expected balance capped at 2, got: %v", stats.Balance)
+		}
+		if stats.Deposits != 12 || stats.Allowed != 3 || stats.Denied != 2 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			attempts++
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(rw, `{"error": {"code": 500, "message": "Internal Error", "status": "INTERNAL"}}`)
+		}))
+		defer server.Close()
+
+		budget, err := NewRetryBudget(0.1, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithRetryBudget(budget),
+			WithRetryPolicy(&ExponentialJitterPolicy{Base: time.Millisecond, Max: time.Millisecond}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := client.SendWithRetry(&NewMessage{Message{Topic: "test"}}, "token", 5); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		}
+		// 3 first attempts and 2 retries allowed by the budget.
+		if attempts != 5 {
+			t.Fatalf("expected 5 attempts, got: %d", attempts)
+		}
+		if stats := budget.Stats(); stats.Denied != 3 {
+			t.Fatalf("expected 3 denied retries, got: %+v", stats)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		budget, err := NewRetryBudget(0.1, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		policy := budgetPolicy{
+			policy: retryPolicyFunc(func(RetryState) (time.Duration, bool) { return time.Hour, true }),
+			budget: budget,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err = retry(ctx, func() error { return ErrInternal }, 3, policy, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline error, got: %v", err)
+		}
+		if stats := budget.Stats(); stats.Allowed != 0 || stats.Balance != 2 {
+			t.Fatalf("expected no token spent on a skipped retry, got: %+v", stats)
+		}
+	})
+
+	t.Run("canceled backoff", func(t *testing.T) {
+		budget, err := NewRetryBudget(0.1, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		policy := budgetPolicy{
+			policy: retryPolicyFunc(func(RetryState) (time.Duration, bool) { return time.Hour, true }),
+			budget: budget,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		err = retry(ctx, func() error { return ErrInternal }, 3, policy, func(RetryState, time.Duration) {
+			cancel()
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context error, got: %v", err)
+		}
+		if stats := budget.Stats(); stats.Allowed != 0 || stats.Balance != 2 {
+			t.Fatalf("expected the token of the abandoned retry to be refunded, got: %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,603 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckpointInterval = 5 * time.Second
+	defaultCheckpointEvery    = 1000
+	defaultCampaignRetryDelay = 10 * time.Second
+	defaultCampaignMaxResends = 3
+)
+
+var (
+	// ErrCampaignCanceled occurs if a campaign was stopped by Cancel.
+	ErrCampaignCanceled = errors.New("campaign canceled")
+	// ErrCampaignRunning occurs if Run is called on a running campaign.
+	ErrCampaignRunning = errors.New("campaign already running")
+)
+
+type (
+	// RecipientIterator provides the messages of a Campaign, one per
+	// recipient, always in the same order so that a campaign can resume.
+	RecipientIterator interface {
+		// Next returns the next message, or false once there are no more.
+		Next() (*NewMessage, bool)
+	}
+
+	// RecipientSeeker is implemented by the iterators which can jump to a
+	// position, e.g. with an offset query, instead of skipping the
+	// recipients already done one by one.
+	RecipientSeeker interface {
+		// SkipTo moves the iterator so that Next returns the recipient at
+		// position, counted from zero.
+		SkipTo(position int64) error
+	}
+
+	// CampaignStats counts the recipients of a campaign.
+	CampaignStats struct {
+		Sent   int64 `json:"sent"`
+		Failed int64 `json:"failed"`
+	}
+
+	// CampaignCheckpoint is the progress of a campaign, as written to its
+	// checkpoint file.
+	CampaignCheckpoint struct {
+		// Position is the number of recipients done: every recipient before
+		// it was sent or failed, a resumed campaign starts from it.
+		// Recipients done after it are sent again on resume.
+		Position int64 `json:"position"`
+		// Stats counts the recipients before Position.
+		Stats CampaignStats `json:"stats"`
+		// Done reports whether every recipient was done.
+		Done      bool      `json:"done"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	// CampaignConfig configures a Campaign.
+	CampaignConfig struct {
+		// AccessToken and TokenSource authorize the sends, see Sender.
+		AccessToken string
+		TokenSource func(ctx context.Context) (string, error)
+		// Concurrency and RetryAttempts configure the sends, see Sender.
+		Concurrency   int
+		RetryAttempts int
+		// CheckpointFile is the path of the checkpoint file. If it exists
+		// when the campaign starts, the campaign resumes from it. Empty
+		// disables checkpoints.
+		CheckpointFile string
+		// CheckpointInterval is how often the checkpoint file is written,
+		// defaults to 5s. It is also written when the campaign stops.
+		CheckpointInterval time.Duration
+		// CheckpointEvery is the number of recipients done after which the
+		// checkpoint file is written, defaults to 1000. Along with
+		// CheckpointInterval, it bounds the recipients sent again when a
+		// campaign resumes after a crash. A recipient waiting to be sent
+		// again holds Position back, see MaxResends.
+		CheckpointEvery int64
+		// RetryDelay is how long a recipient waits to be sent again after
+		// its send failed with an error classified as ActionRetry or
+		// ActionRetryLater, or the TokenSource failed, defaults to 10s. The
+		// Error.RetryAfter delay is used instead if longer. The other
+		// recipients are sent meanwhile.
+		RetryDelay time.Duration
+		// MaxResends is the number of times a recipient is sent again,
+		// defaults to 3. A recipient still failing then counts as failed.
+		MaxResends int
+		// OnResult, if set, is called with the position and the result of
+		// every send, e.g. to remove unregistered tokens. A recipient sent
+		// again after a transient failure is reported once per send. It is
+		// called from Run and must not block.
+		OnResult func(position int64, res SendResult)
+	}
+)
+
+// Campaign sends a message to every recipient of an iterator, e.g. a whole
+// user base, with checkpoints allowing to resume it after a crash. Only the
+// recipients done since the last checkpoint are notified again, see
+// CheckpointEvery. The sends go through the bulk lane of the rate limiter,
+// see WithLane.
+//
+// Pause, Resume and Cancel may be called from other goroutines while the
+// campaign runs.
+type Campaign struct {
+	client     *Client
+	recipients RecipientIterator
+	cfg        CampaignConfig
+
+	mu       sync.Mutex
+	running  bool
+	resumed  chan struct{}
+	canceled chan struct{}
+	cancel   sync.Once
+	progress campaignProgress
+	saved    int64
+	// The sends in flight and the recipients to send again, changed is
+	// closed whenever a send completes.
+	inFlight int
+	retries  []campaignRetry
+	changed  chan struct{}
+}
+
+// campaignRecipient is the key of the requests of a campaign.
+type campaignRecipient struct {
+	position int64
+	msg      *NewMessage
+	resends  int
+}
+
+// campaignRetry is a recipient to send again once notBefore has passed.
+type campaignRetry struct {
+	recipient campaignRecipient
+	notBefore time.Time
+}
+
+// tokenSourceError wraps the errors of the TokenSource of a campaign, whose
+// recipients are sent again.
+type tokenSourceError struct {
+	err error
+}
+
+func (err tokenSourceError) Error() string {
+	return err.err.Error()
+}
+
+func (err tokenSourceError) Unwrap() error {
+	return err.err
+}
+
+// campaignProgress tracks the recipients done, which complete out of order,
+// to compute the position all recipients before which are done.
+type campaignProgress struct {
+	CampaignCheckpoint
+	done map[int64]bool
+}
+
+// complete records that the recipient at position was done.
+func (p *campaignProgress) complete(position int64, ok bool) {
+	p.done[position] = ok
+	for {
+		ok, found := p.done[p.Position]
+		if !found {
+			return
+		}
+		delete(p.done, p.Position)
+		if ok {
+			p.Stats.Sent++
+		} else {
+			p.Stats.Failed++
+		}
+		p.Position++
+	}
+}
+
+// NewCampaign creates a Campaign sending the messages of recipients with c.
+func NewCampaign(c *Client, recipients RecipientIterator, cfg CampaignConfig) *Campaign {
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = defaultCheckpointInterval
+	}
+	if cfg.CheckpointEvery <= 0 {
+		cfg.CheckpointEvery = defaultCheckpointEvery
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaultCampaignRetryDelay
+	}
+	if cfg.MaxResends <= 0 {
+		cfg.MaxResends = defaultCampaignMaxResends
+	}
+	return &Campaign{
+		client:     c,
+		recipients: recipients,
+		cfg:        cfg,
+		canceled:   make(chan struct{}),
+	}
+}
+
+// LoadCampaignCheckpoint reads a checkpoint file.
+func LoadCampaignCheckpoint(path string) (*CampaignCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(CampaignCheckpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Run sends the campaign until every recipient is done, ctx is done or
+// Cancel is called, and returns the final checkpoint. On Cancel, the sends
+// in flight complete and ErrCampaignCanceled is returned. Once ctx is done,
+// the results of the sends in flight are lost and the recipients will be
+// sent again on resume.
+func (cp *Campaign) Run(ctx context.Context) (CampaignCheckpoint, error) {
+	switch {
+	case cp.client == nil:
+		return CampaignCheckpoint{}, ErrMissingClient
+	case cp.cfg.AccessToken == "" && cp.cfg.TokenSource == nil:
+		return CampaignCheckpoint{}, ErrMissingAccessToken
+	}
+
+	cp.mu.Lock()
+	if cp.running {
+		cp.mu.Unlock()
+		return CampaignCheckpoint{}, ErrCampaignRunning
+	}
+	cp.running = true
+	cp.mu.Unlock()
+	defer func() {
+		cp.mu.Lock()
+		cp.running = false
+		cp.mu.Unlock()
+	}()
+
+	if err := cp.restore(); err != nil {
+		return cp.Progress(), err
+	}
+
+	// Stop the sends if Run returns early.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	in := make(chan SendRequest)
+	exhausted := make(chan bool, 1)
+	go cp.feed(ctx, cp.Progress().Position, in, exhausted)
+
+	s := &Sender{
+		Client:        cp.client,
+		AccessToken:   cp.cfg.AccessToken,
+		Concurrency:   cp.cfg.Concurrency,
+		RetryAttempts: cp.cfg.RetryAttempts,
+	}
+	if tokenSource := cp.cfg.TokenSource; tokenSource != nil {
+		s.TokenSource = func(ctx context.Context) (string, error) {
+			token, err := tokenSource(ctx)
+			if err != nil {
+				return "", tokenSourceError{err}
+			}
+			return token, nil
+		}
+	}
+	results := s.Run(WithLane(ctx, LaneBulk), in)
+
+	ticker := time.NewTicker(cp.cfg.CheckpointInterval)
+	defer ticker.Stop()
+	for results != nil {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			cp.complete(ctx, res)
+			if cp.Progress().Position-cp.saved >= cp.cfg.CheckpointEvery {
+				if err := cp.checkpoint(); err != nil {
+					return cp.Progress(), err
+				}
+			}
+		case <-ticker.C:
+			if err := cp.checkpoint(); err != nil {
+				return cp.Progress(), err
+			}
+		}
+	}
+
+	allSent := <-exhausted
+	cp.mu.Lock()
+	cp.progress.Done = allSent && len(cp.progress.done) == 0 && ctx.Err() == nil
+	cp.mu.Unlock()
+	if err := cp.checkpoint(); err != nil {
+		return cp.Progress(), err
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return cp.Progress(), ctx.Err()
+	case cp.isCanceled():
+		return cp.Progress(), ErrCampaignCanceled
+	}
+	return cp.Progress(), nil
+}
+
+// restore resumes the campaign from its checkpoint file, if any.
+func (cp *Campaign) restore() error {
+	var position int64
+	cp.mu.Lock()
+	cp.progress = campaignProgress{done: make(map[int64]bool)}
+	cp.saved = 0
+	cp.inFlight = 0
+	cp.retries = nil
+	cp.changed = make(chan struct{})
+	if cp.cfg.CheckpointFile != "" {
+		saved, err := LoadCampaignCheckpoint(cp.cfg.CheckpointFile)
+		switch {
+		case err == nil:
+			cp.progress.CampaignCheckpoint = *saved
+			cp.progress.Done = false
+			position = saved.Position
+			cp.saved = position
+		case !os.IsNotExist(err):
+			cp.mu.Unlock()
+			return err
+		}
+	}
+	cp.mu.Unlock()
+
+	if position == 0 {
+		return nil
+	}
+	if seeker, ok := cp.recipients.(RecipientSeeker); ok {
+		return seeker.SkipTo(position)
+	}
+	for i := int64(0); i < position; i++ {
+		if _, ok := cp.recipients.Next(); !ok {
+			break
+		}
+	}
+	return nil
+}
+
+// feed sends the recipients from position to in, and the recipients to
+// send again once their backoff elapsed, until they are exhausted or the
+// campaign is canceled, and reports whether they were exhausted.
+func (cp *Campaign) feed(ctx context.Context, position int64, in chan<- SendRequest, exhausted chan<- bool) {
+	defer close(in)
+	more := true
+	for {
+		if !cp.waitResumed(ctx) {
+			exhausted <- false
+			return
+		}
+		r, ok := cp.dueRetry()
+		if !ok && more {
+			var msg *NewMessage
+			if msg, more = cp.recipients.Next(); more {
+				r = campaignRecipient{position: position, msg: msg}
+				ok = true
+				position++
+			}
+		}
+		if !ok {
+			// Wait for the sends in flight, which may fail and have to be
+			// sent again, or for the backoff of a recipient to send again.
+			if !cp.waitPending(ctx) {
+				exhausted <- cp.isIdle()
+				return
+			}
+			continue
+		}
+
+		cp.mu.Lock()
+		cp.inFlight++
+		cp.mu.Unlock()
+		select {
+		case in <- SendRequest{Key: r, Message: r.msg}:
+		case <-cp.canceled:
+			exhausted <- false
+			return
+		case <-ctx.Done():
+			exhausted <- false
+			return
+		}
+	}
+}
+
+// dueRetry returns the first recipient to send again whose backoff elapsed,
+// if any.
+func (cp *Campaign) dueRetry() (campaignRecipient, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	now := time.Now()
+	for i, retry := range cp.retries {
+		if !retry.notBefore.After(now) {
+			cp.retries = append(cp.retries[:i], cp.retries[i+1:]...)
+			return retry.recipient, true
+		}
+	}
+	return campaignRecipient{}, false
+}
+
+// isIdle reports whether no send is in flight and no recipient waits to be
+// sent again.
+func (cp *Campaign) isIdle() bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.inFlight == 0 && len(cp.retries) == 0
+}
+
+// waitPending blocks until a send completes or the backoff of a recipient
+// to send again elapses, and reports whether there may be more to send.
+func (cp *Campaign) waitPending(ctx context.Context) bool {
+	cp.mu.Lock()
+	if cp.inFlight == 0 && len(cp.retries) == 0 {
+		cp.mu.Unlock()
+		return false
+	}
+	changed := cp.changed
+	var due <-chan time.Time
+	if len(cp.retries) > 0 {
+		next := cp.retries[0].notBefore
+		for _, retry := range cp.retries[1:] {
+			if retry.notBefore.Before(next) {
+				next = retry.notBefore
+			}
+		}
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		due = timer.C
+	}
+	cp.mu.Unlock()
+
+	select {
+	case <-changed:
+	case <-due:
+	case <-cp.canceled:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// waitResumed blocks while the campaign is paused, and reports whether it
+// may go on.
+func (cp *Campaign) waitResumed(ctx context.Context) bool {
+	cp.mu.Lock()
+	resumed := cp.resumed
+	cp.mu.Unlock()
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-cp.canceled:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return !cp.isCanceled() && ctx.Err() == nil
+}
+
+// complete records the result of a send. Sends interrupted by ctx are not
+// done and are sent again on resume. Sends which failed transiently are not
+// done either, the recipient is sent again after its backoff, at most
+// MaxResends times.
+func (cp *Campaign) complete(ctx context.Context, res SendResult) {
+	r := res.Key.(campaignRecipient)
+	cp.mu.Lock()
+	cp.inFlight--
+	close(cp.changed)
+	cp.changed = make(chan struct{})
+	if res.Err != nil && ctx.Err() != nil {
+		cp.mu.Unlock()
+		return
+	}
+	if mustResend(res.Err) && r.resends < cp.cfg.MaxResends {
+		delay := cp.cfg.RetryDelay
+		var fcmErr *Error
+		if errors.As(res.Err, &fcmErr) && fcmErr.RetryAfter > delay {
+			delay = fcmErr.RetryAfter
+		}
+		r.resends++
+		cp.retries = append(cp.retries, campaignRetry{recipient: r, notBefore: time.Now().Add(delay)})
+	} else {
+		cp.progress.complete(r.position, res.Err == nil)
+	}
+	cp.mu.Unlock()
+	if cp.cfg.OnResult != nil {
+		cp.cfg.OnResult(r.position, res)
+	}
+}
+
+// mustResend reports whether a recipient whose send failed with err must be
+// sent again: the transient failures, and the failures to get an access
+// token.
+func mustResend(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch Classify(err) {
+	case ActionRetry, ActionRetryLater:
+		return true
+	}
+	var tokenErr tokenSourceError
+	return errors.As(err, &tokenErr) || errors.Is(err, ErrMissingAccessToken)
+}
+
+// checkpoint writes the checkpoint file. It is first written to a temporary
+// file which is then renamed, so that a crash never leaves a partial one.
+func (cp *Campaign) checkpoint() error {
+	if cp.cfg.CheckpointFile == "" {
+		return nil
+	}
+	cp.mu.Lock()
+	cp.progress.UpdatedAt = time.Now()
+	position := cp.progress.Position
+	data, err := json.Marshal(cp.progress.CampaignCheckpoint)
+	cp.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := cp.cfg.CheckpointFile
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	cp.saved = position
+	return nil
+}
+
+// Progress returns the current progress of the campaign.
+func (cp *Campaign) Progress() CampaignCheckpoint {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.progress.CampaignCheckpoint
+}
+
+// Pause stops sending to new recipients, the sends in flight complete.
+func (cp *Campaign) Pause() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.resumed == nil {
+		cp.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes a paused campaign.
+func (cp *Campaign) Resume() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.resumed != nil {
+		close(cp.resumed)
+		cp.resumed = nil
+	}
+}
+
+// Paused reports whether the campaign is paused.
+func (cp *Campaign) Paused() bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.resumed != nil
+}
+
+// Cancel stops the campaign: no more recipients are sent to and Run returns
+// ErrCampaignCanceled once the sends in flight complete. A canceled
+// campaign can't be run again, resume it with a new Campaign.
+func (cp *Campaign) Cancel() {
+	cp.cancel.Do(func() { close(cp.canceled) })
+}
+
+func (cp *Campaign) isCanceled() bool {
+	select {
+	case <-cp.canceled:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,397 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type recipientIterator struct {
+	tokens []string
+	pos    int
+}
+
+func (it *recipientIterator) Next() (*NewMessage, bool) {
+	if it.pos >= len(it.tokens) {
+		return nil, false
+	}
+	it.pos++
+	return &NewMessage{Message{Token: it.tokens[it.pos-1]}}, true
+}
+
+type seekingIterator struct {
+	recipientIterator
+	skipped bool
+}
+
+func (it *seekingIterator) SkipTo(position int64) error {
+	it.pos = int(position)
+	it.skipped = true
+	return nil
+}
+
+func newRecipients(n int) *recipientIterator {
+	it := &recipientIterator{}
+	for i := 0; i < n; i++ {
+		token := fmt.Sprintf("t%d", i)
+		if i%4 == 3 {
+			token = "bad" + token
+		}
+		it.tokens = append(it.tokens, token)
+	}
+	return it
+}
+
+type campaignServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	tokens []string
+	gate   chan struct{}
+}
+
+func newCampaignServer() *campaignServer {
+	s := &campaignServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var msg NewMessage
+		json.NewDecoder(req.Body).Decode(&msg)
+		s.mu.Lock()
+		s.tokens = append(s.tokens, msg.Message.Token)
+		gate := s.gate
+		s.mu.Unlock()
+		if gate != nil {
+			<-gate
+		}
+		if strings.HasPrefix(msg.Message.Token, "bad") {
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `{"error": {"code": 404, "status": "NOT_FOUND", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`)
+			return
+		}
+		fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+	}))
+	return s
+}
+
+func (s *campaignServer) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+func TestCampaignProgress(t *testing.T) {
+	p := campaignProgress{done: make(map[int64]bool)}
+	p.complete(1, true)
+	p.complete(2, false)
+	if p.Position != 0 {
+		t.Fatalf("expected position to wait for 0, got: %d", p.Position)
+	}
+	p.complete(0, true)
+	p.complete(4, true)
+	if p.Position != 3 || p.Stats.Sent != 2 || p.Stats.Failed != 1 || len(p.done) != 1 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "campaign")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newCampaignServer()
+	defer server.Close()
+	client, err := NewClient("test", WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("campaign=run", func(t *testing.T) {
+		file := filepath.Join(dir, "run.json")
+		var unregistered []int64
+		c := NewCampaign(client, newRecipients(20), CampaignConfig{
+			AccessToken:    "token",
+			Concurrency:    3,
+			CheckpointFile: file,
+			OnResult: func(position int64, res SendResult) {
+				if Classify(res.Err) == ActionDropToken {
+					unregistered = append(unregistered, position)
+				}
+			},
+		})
+		cp, err := c.Run(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !cp.Done || cp.Position != 20 || cp.Stats.Sent != 15 || cp.Stats.Failed != 5 || len(unregistered) != 5 {
+			t.Fatalf("unexpected checkpoint: %+v, %d unregistered", cp, len(unregistered))
+		}
+		saved, err := LoadCampaignCheckpoint(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if saved.Position != cp.Position || saved.Stats != cp.Stats || !saved.Done || !saved.UpdatedAt.Equal(cp.UpdatedAt) {
+			t.Fatalf("expected checkpoint file %+v, got: %+v", cp, saved)
+		}
+	})
+
+	t.Run("campaign=resume", func(t *testing.T) {
+		file := filepath.Join(dir, "resume.json")
+		data, _ := json.Marshal(CampaignCheckpoint{Position: 10, Stats: CampaignStats{Sent: 8, Failed: 2}})
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, it := range []RecipientIterator{newRecipients(20), &seekingIterator{recipientIterator: *newRecipients(20)}} {
+			server.mu.Lock()
+			server.tokens = nil
+			server.mu.Unlock()
+
+			c := NewCampaign(client, it, CampaignConfig{AccessToken: "token", CheckpointFile: file})
+			cp, err := c.Run(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cp.Position != 20 || cp.Stats.Sent != 15 || cp.Stats.Failed != 5 {
+				t.Fatalf("unexpected checkpoint: %+v", cp)
+			}
+			for _, token := range server.sent() {
+				var i int
+				fmt.Sscanf(strings.TrimPrefix(token, "bad"), "t%d", &i)
+				if i < 10 {
+					t.Fatalf("expected recipients before the checkpoint to be skipped, got: %s", token)
+				}
+			}
+			if s, ok := it.(*seekingIterator); ok && !s.skipped {
+				t.Fatal("expected iterator to be skipped to the position")
+			}
+			// Run again from the start for the next iterator.
+			ioutil.WriteFile(file, data, 0600)
+		}
+	})
+
+	t.Run("campaign=pause_cancel", func(t *testing.T) {
+		file := filepath.Join(dir, "cancel.json")
+		server.mu.Lock()
+		server.tokens = nil
+		server.mu.Unlock()
+		c := NewCampaign(client, newRecipients(100), CampaignConfig{
+			AccessToken:        "token",
+			Concurrency:        2,
+			CheckpointFile:     file,
+			CheckpointInterval: 10 * time.Millisecond,
+		})
+		c.Pause()
+		if !c.Paused() {
+			t.Fatal("expected campaign to be paused")
+		}
+
+		done := make(chan error, 1)
+		var cp CampaignCheckpoint
+		go func() {
+			var err error
+			cp, err = c.Run(context.Background())
+			done <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		if n := len(server.sent()); n != 0 {
+			t.Fatalf("expected no sends while paused, got: %d", n)
+		}
+		if _, err := c.Run(context.Background()); err != ErrCampaignRunning {
+			t.Fatalf("expected <%v> error, got: %v", ErrCampaignRunning, err)
+		}
+
+		server.mu.Lock()
+		server.gate = make(chan struct{})
+		gate := server.gate
+		server.mu.Unlock()
+
+		c.Resume()
+		for len(server.sent()) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		c.Cancel()
+		close(gate)
+
+		if err := <-done; !errors.Is(err, ErrCampaignCanceled) {
+			t.Fatalf("expected <%v> error, got: %v", ErrCampaignCanceled, err)
+		}
+		server.mu.Lock()
+		server.gate = nil
+		server.mu.Unlock()
+
+		sent := int64(len(server.sent()))
+		if cp.Done || cp.Position != sent || cp.Stats.Sent+cp.Stats.Failed != sent {
+			t.Fatalf("expected the %d sends in flight to complete, got: %+v", sent, cp)
+		}
+		saved, err := LoadCampaignCheckpoint(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if saved.Position != cp.Position {
+			t.Fatalf("expected checkpoint at %d, got: %d", cp.Position, saved.Position)
+		}
+	})
+
+	t.Run("campaign=context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := NewCampaign(client, newRecipients(10), CampaignConfig{AccessToken: "token"})
+		c.Pause()
+		time.AfterFunc(20*time.Millisecond, cancel)
+		if _, err := c.Run(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context error, got: %v", err)
+		}
+	})
+
+	t.Run("campaign=retry", func(t *testing.T) {
+		var requests int32
+		flaky := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			var msg NewMessage
+			json.NewDecoder(req.Body).Decode(&msg)
+			if msg.Message.Token == "t2" && atomic.AddInt32(&requests, 1) == 1 {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+		}))
+		defer flaky.Close()
+		flakyClient, err := NewClient("test", WithEndpoint(flaky.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var tokenErrs int
+		var results []int64
+		c := NewCampaign(flakyClient, &recipientIterator{tokens: []string{"t0", "t1", "t2", "t3", "t4"}}, CampaignConfig{
+			TokenSource: func(ctx context.Context) (string, error) {
+				if tokenErrs == 0 {
+					tokenErrs++
+					return "", errors.New("token unavailable")
+				}
+				return "token", nil
+			},
+			Concurrency: 1,
+			RetryDelay:  time.Millisecond,
+			OnResult: func(position int64, res SendResult) {
+				results = append(results, position)
+			},
+		})
+		cp, err := c.Run(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !cp.Done || cp.Position != 5 || cp.Stats.Sent != 5 || cp.Stats.Failed != 0 {
+			t.Fatalf("expected transient failures to be sent again, got: %+v", cp)
+		}
+		if len(results) != 7 {
+			t.Fatalf("expected 7 sends, got: %v", results)
+		}
+	})
+
+	t.Run("campaign=backoff", func(t *testing.T) {
+		var mu sync.Mutex
+		var sent []string
+		failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			var msg NewMessage
+			json.NewDecoder(req.Body).Decode(&msg)
+			mu.Lock()
+			sent = append(sent, msg.Message.Token)
+			mu.Unlock()
+			if msg.Message.Token == "t0" {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+		}))
+		defer failing.Close()
+		failingClient, err := NewClient("test", WithEndpoint(failing.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		c := NewCampaign(failingClient, &recipientIterator{tokens: []string{"t0", "t1", "t2", "t3", "t4"}}, CampaignConfig{
+			AccessToken: "token",
+			Concurrency: 1,
+			RetryDelay:  20 * time.Millisecond,
+			MaxResends:  2,
+		})
+		cp, err := c.Run(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !cp.Done || cp.Position != 5 || cp.Stats.Sent != 4 || cp.Stats.Failed != 1 {
+			t.Fatalf("expected the recipient to fail after its resends, got: %+v", cp)
+		}
+		expected := []string{"t0", "t1", "t2", "t3", "t4", "t0", "t0"}
+		if !reflect.DeepEqual(sent, expected) {
+			t.Fatalf("expected only the failing recipient to back off, sends %v, got: %v", expected, sent)
+		}
+	})
+
+	t.Run("campaign=retry_after", func(t *testing.T) {
+		c := NewCampaign(client, newRecipients(3), CampaignConfig{AccessToken: "token"})
+		c.restore()
+		if c.cfg.RetryDelay != defaultCampaignRetryDelay || c.cfg.MaxResends != defaultCampaignMaxResends {
+			t.Fatalf("expected defaults, got: %v, %d", c.cfg.RetryDelay, c.cfg.MaxResends)
+		}
+		c.inFlight = 1
+		c.complete(context.Background(), SendResult{
+			Key: campaignRecipient{position: 0},
+			Err: &Error{HTTPStatus: http.StatusTooManyRequests, Code: ErrorCodeQuotaExceeded, RetryAfter: time.Minute},
+		})
+		if p := c.Progress(); p.Position != 0 {
+			t.Fatalf("expected the position not to advance, got: %d", p.Position)
+		}
+		if len(c.retries) != 1 || time.Until(c.retries[0].notBefore) < 50*time.Second {
+			t.Fatalf("expected the recipient to be sent again after the Retry-After delay, got: %+v", c.retries)
+		}
+		if _, ok := c.dueRetry(); ok {
+			t.Fatal("expected the recipient to wait for its backoff")
+		}
+	})
+
+	t.Run("campaign=checkpoint_every", func(t *testing.T) {
+		file := filepath.Join(dir, "every.json")
+		var c *Campaign
+		c = NewCampaign(client, newRecipients(20), CampaignConfig{
+			AccessToken:        "token",
+			Concurrency:        1,
+			CheckpointFile:     file,
+			CheckpointInterval: time.Hour,
+			CheckpointEvery:    5,
+			OnResult: func(position int64, res SendResult) {
+				var saved int64
+				if cp, err := LoadCampaignCheckpoint(file); err == nil {
+					saved = cp.Position
+				}
+				if unsaved := c.Progress().Position - saved; unsaved > 5 {
+					t.Errorf("expected at most 5 recipients done since the checkpoint, got: %d", unsaved)
+				}
+			},
+		})
+		if _, err := c.Run(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("campaign=misconfigured", func(t *testing.T) {
+		if _, err := NewCampaign(nil, newRecipients(1), CampaignConfig{AccessToken: "token"}).Run(context.Background()); err != ErrMissingClient {
+			t.Fatalf("expected <%v> error, got: %v", ErrMissingClient, err)
+		}
+		if _, err := NewCampaign(client, newRecipients(1), CampaignConfig{}).Run(context.Background()); err != ErrMissingAccessToken {
+			t.Fatalf("expected <%v> error, got: %v", ErrMissingAccessToken, err)
+		}
+	})
+}
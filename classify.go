@@ -0,0 +1,135 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Action is the recommended reaction to an error returned by the Client.
+type Action int
+
+const (
+	// ActionNone means there is nothing to do, the send succeeded.
+	ActionNone Action = iota
+	// ActionRetry means the failure is transient and the message can be
+	// retried right away with backoff.
+	ActionRetry
+	// ActionRetryLater means the server is throttling or overloaded and the
+	// message should be retried after a longer delay, see Error.RetryAfter.
+	ActionRetryLater
+	// ActionDropToken means the registration token is no longer valid and
+	// should be removed.
+	ActionDropToken
+	// ActionFixPayload means the message is invalid and must be fixed before
+	// it can be sent.
+	ActionFixPayload
+	// ActionFixCredentials means the access token or the APNs/web push
+	// credentials configured in the project are invalid.
+	ActionFixCredentials
+	// ActionFatal means the error is not recoverable by the library, someone
+	// has to look into it.
+	ActionFatal
+)
+
+var actionNames = [...]string{
+	ActionNone:           "none",
+	ActionRetry:          "retry",
+	ActionRetryLater:     "retry_later",
+	ActionDropToken:      "drop_token",
+	ActionFixPayload:     "fix_payload",
+	ActionFixCredentials: "fix_credentials",
+	ActionFatal:          "fatal",
+}
+
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return "unknown"
+	}
+	return actionNames[a]
+}
+
+// Classify returns the recommended Action for an error returned by the
+// Client. It covers FCM error codes, HTTP statuses, network errors and
+// timeouts; errors it doesn't know are classified as ActionFatal.
+func Classify(err error) Action {
+	if err == nil {
+		return ActionNone
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ActionFatal
+	}
+
+	var fcmErr *Error
+	if errors.As(err, &fcmErr) {
+		return classifyFCMError(fcmErr)
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ActionRetry
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrTargetThrottled):
+		return ActionRetryLater
+	case errors.Is(err, ErrInvalidMessage),
+		errors.Is(err, ErrInvalidTarget),
+		errors.Is(err, ErrToManyRegIDs),
+		errors.Is(err, ErrInvalidTimeToLive),
+		errors.Is(err, ErrUnknownChannel),
+		errors.Is(err, ErrChannelBlocked),
+		errors.Is(err, ErrUnknownCategory):
+		return ActionFixPayload
+	}
+
+	var connErr connectionError
+	if errors.As(err, &connErr) {
+		return ActionRetry
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+		return ActionRetry
+	}
+	return ActionFatal
+}
+
+// classifyFCMError maps the FCM error code or, if the server didn't return
+// one, the HTTP status to an Action.
+func classifyFCMError(e *Error) Action {
+	switch e.Code {
+	case ErrorCodeUnregistered, ErrorCodeSenderIdMismatch:
+		return ActionDropToken
+	case ErrorCodeInvalidArgument:
+		return ActionFixPayload
+	case ErrorCodeThirdPartyAuthError:
+		return ActionFixCredentials
+	case ErrorCodeQuotaExceeded:
+		return ActionRetryLater
+	case ErrorCodeUnavailable:
+		return retryAfter(e)
+	case ErrorCodeInternal:
+		return ActionRetry
+	}
+
+	switch {
+	case e.HTTPStatus == http.StatusBadRequest:
+		return ActionFixPayload
+	case e.HTTPStatus == http.StatusUnauthorized, e.HTTPStatus == http.StatusForbidden:
+		return ActionFixCredentials
+	case e.HTTPStatus == http.StatusTooManyRequests:
+		return ActionRetryLater
+	case e.HTTPStatus == http.StatusServiceUnavailable:
+		return retryAfter(e)
+	case e.HTTPStatus >= http.StatusInternalServerError:
+		return ActionRetry
+	}
+	return ActionFatal
+}
+
+// retryAfter returns ActionRetryLater if the server asked for a delay.
+func retryAfter(e *Error) Action {
+	if e.RetryAfter > 0 {
+		return ActionRetryLater
+	}
+	return ActionRetry
+}
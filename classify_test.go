@@ -0,0 +1,58 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected Action
+	}{
+		{"nil", nil, ActionNone},
+		{"unregistered", &Error{HTTPStatus: 404, Code: ErrorCodeUnregistered}, ActionDropToken},
+		{"sender id mismatch", &Error{HTTPStatus: 403, Code: ErrorCodeSenderIdMismatch}, ActionDropToken},
+		{"invalid argument", &Error{HTTPStatus: 400, Code: ErrorCodeInvalidArgument}, ActionFixPayload},
+		{"third party auth", &Error{HTTPStatus: 401, Code: ErrorCodeThirdPartyAuthError}, ActionFixCredentials},
+		{"quota exceeded", &Error{HTTPStatus: 429, Code: ErrorCodeQuotaExceeded}, ActionRetryLater},
+		{"unavailable", &Error{HTTPStatus: 503, Code: ErrorCodeUnavailable}, ActionRetry},
+		{"unavailable with delay", &Error{HTTPStatus: 503, Code: ErrorCodeUnavailable, RetryAfter: time.Second}, ActionRetryLater},
+		{"internal", &Error{HTTPStatus: 500, Code: ErrorCodeInternal}, ActionRetry},
+		{"http 400", &Error{HTTPStatus: 400, Code: ErrorCodeUnspecifiedError}, ActionFixPayload},
+		{"http 401", &Error{HTTPStatus: 401, Code: ErrorCodeUnspecifiedError}, ActionFixCredentials},
+		{"http 403", &Error{HTTPStatus: 403, Code: ErrorCodeUnspecifiedError}, ActionFixCredentials},
+		{"http 404", &Error{HTTPStatus: 404, Code: ErrorCodeUnspecifiedError}, ActionFatal},
+		{"http 429", &Error{HTTPStatus: 429, Code: ErrorCodeUnspecifiedError}, ActionRetryLater},
+		{"http 502", &Error{HTTPStatus: 502, Code: ErrorCodeUnspecifiedError}, ActionRetry},
+		{"wrapped", fmt.Errorf("send: %w", &Error{HTTPStatus: 404, Code: ErrorCodeUnregistered}), ActionDropToken},
+		{"connection", connectionError{errors.New("connection refused")}, ActionRetry},
+		{"deadline", context.DeadlineExceeded, ActionRetry},
+		{"canceled", context.Canceled, ActionFatal},
+		{"invalid message", ErrInvalidMessage, ActionFixPayload},
+		{"unknown channel", fmt.Errorf("%w: %q", ErrUnknownChannel, "x"), ActionFixPayload},
+		{"blocked channel", fmt.Errorf("%w: %q", ErrChannelBlocked, "x"), ActionFixPayload},
+		{"unknown", errors.New("boom"), ActionFatal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestActionString(t *testing.T) {
+	if ActionDropToken.String() != "drop_token" {
+		t.Fatalf("unexpected name: %s", ActionDropToken)
+	}
+	if Action(42).String() != "unknown" {
+		t.Fatalf("unexpected name: %s", Action(42))
+	}
+}
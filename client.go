@@ -1,10 +1,12 @@
 package fcm
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 )
@@ -23,12 +25,26 @@ const (
 // perform authorized requests on the application server's behalf.
 // To send a message to one or more devices use the Client's Send.
 //
-// If the `HTTP` field is nil, a zeroed http.Client will be allocated and used
-// to send messages.
+// Unless WithHTTPClient is used, the messages are sent with a dedicated
+// transport tuned for FCM, see WithTransport.
 type Client struct {
-	client   *http.Client
-	endpoint string
-	timeout  time.Duration
+	client    *http.Client
+	transport *TransportConfig
+	endpoint  string
+	timeout   time.Duration
+	registry  *Registry
+	policy    RetryPolicy
+	budget    *RetryBudget
+	breaker   *CircuitBreaker
+	throttle  *targetThrottle
+	limiter   *rateLimiter
+	reserved  float64
+
+	concurrency int
+	adaptive    *AdaptiveLimiter
+
+	onRetry   func(RetryEvent)
+	onOutcome func(RetryOutcome)
 }
 
 // NewClient creates new Firebase Cloud Messaging Client based on API key and
@@ -36,14 +52,32 @@ type Client struct {
 func NewClient(projectId string, opts ...Option) (*Client, error) {
 	c := &Client{
 		endpoint: fmt.Sprintf(DefaultEndpoint, projectId),
-		client:   &http.Client{},
 		timeout:  DefaultTimeout,
+		policy:   quadraticPolicy{},
+
+		concurrency: DefaultMaxConcurrency,
 	}
 	for _, o := range opts {
 		if err := o(c); err != nil {
 			return nil, err
 		}
 	}
+	switch {
+	case c.client != nil && c.transport != nil:
+		return nil, errors.New("http client and transport options are mutually exclusive")
+	case c.client == nil:
+		var cfg TransportConfig
+		if c.transport != nil {
+			cfg = *c.transport
+		}
+		c.client = &http.Client{Transport: newTransport(cfg)}
+	}
+	if c.reserved > 0 {
+		if c.limiter == nil {
+			return nil, errors.New("reserved capacity requires a rate limit")
+		}
+		c.limiter.setReserved(c.reserved)
+	}
 
 	return c, nil
 }
@@ -54,17 +88,23 @@ func NewClient(projectId string, opts ...Option) (*Client, error) {
 // Behaves just like regular send, but uses external context.
 func (c *Client) SendWithContext(ctx context.Context, accessToken string, msg *NewMessage) (*Response, error) {
 	// validate
-	if err := msg.Validate(); err != nil {
+	if err := c.validate(msg); err != nil {
 		return nil, err
 	}
 
 	// marshal message
-	data, err := json.Marshal(msg)
+	p, err := encodePayload(msg)
 	if err != nil {
 		return nil, err
 	}
+	defer p.release()
 
-	return c.send(ctx, accessToken, data)
+	resp, err := c.send(ctx, accessToken, targetKey(msg), p)
+	if err != nil {
+		return nil, err
+	}
+	c.recordSent(msg)
+	return resp, nil
 }
 
 // Send sends a message to the FCM server without retrying in case of service
@@ -88,37 +128,166 @@ func (c *Client) SendWithRetry(msg *NewMessage, accessToken string, retryAttempt
 // Behaves just like regular SendWithRetry, but uses external context.
 func (c *Client) SendWithRetryWithContext(ctx context.Context, msg *NewMessage, accessToken string, retryAttempts int) (*Response, error) {
 	// validate
-	if err := msg.Validate(); err != nil {
+	if err := c.validate(msg); err != nil {
 		return nil, err
 	}
 	// marshal message
-	data, err := json.Marshal(msg)
+	p, err := encodePayload(msg)
 	if err != nil {
 		return nil, err
 	}
+	defer p.release()
 
+	target := targetKey(msg)
+	start := time.Now()
+	var latencies []time.Duration
 	resp := new(Response)
-	err = retry(func() error {
+	err = retry(ctx, func() error {
 		ctx, cancel := context.WithTimeout(ctx, c.timeout)
 		defer cancel()
+		attemptStart := time.Now()
 		var er error
-		resp, er = c.send(ctx, accessToken, data)
+		resp, er = c.send(ctx, accessToken, target, p)
+		latencies = append(latencies, time.Since(attemptStart))
 		return er
-	}, retryAttempts)
+	}, retryAttempts, c.retryPolicy(), c.retryHook(target))
+
+	if c.onOutcome != nil {
+		c.onOutcome(RetryOutcome{
+			Target:   target,
+			Attempts: len(latencies),
+			Elapsed:  time.Since(start),
+			Err:      err,
+		})
+	}
+	var metadata *ResponseMetadata
+	var fcmErr *Error
+	switch {
+	case err == nil:
+		metadata = &resp.Metadata
+	case errors.As(err, &fcmErr):
+		metadata = &fcmErr.Metadata
+	}
+	if metadata != nil {
+		metadata.Latency = time.Since(start)
+		metadata.AttemptLatencies = latencies
+		metadata.Attempts = len(latencies)
+	}
 	if err != nil {
 		return nil, err
 	}
-
+	c.recordSent(msg)
 	return resp, nil
 }
 
-// send sends a request.
-func (c *Client) send(ctx context.Context, accessToken string, data []byte) (*Response, error) {
+// retryPolicy returns the policy used by SendWithRetry, bound by the retry
+// budget if one is configured.
+func (c *Client) retryPolicy() RetryPolicy {
+	if c.budget == nil {
+		return c.policy
+	}
+	return budgetPolicy{policy: c.policy, budget: c.budget}
+}
+
+// retryHook returns the callback reporting the retries of a send to target
+// to the OnRetry hook, if configured.
+func (c *Client) retryHook(target string) func(RetryState, time.Duration) {
+	if c.onRetry == nil {
+		return nil
+	}
+	return func(state RetryState, backoff time.Duration) {
+		c.onRetry(RetryEvent{
+			Target:  target,
+			Attempt: state.Attempt,
+			Err:     state.Err,
+			Action:  state.Action,
+			Backoff: backoff,
+		})
+	}
+}
+
+// validate checks the message itself and, if configured, against the
+// registry of declared channels and categories.
+func (c *Client) validate(msg *NewMessage) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if c.registry != nil {
+		return c.registry.Validate(msg)
+	}
+	return nil
+}
+
+// recordSent counts a message sent in the registry, if configured.
+func (c *Client) recordSent(msg *NewMessage) {
+	if c.registry != nil {
+		c.registry.record(msg)
+	}
+}
+
+// send sends a request to target, waiting for its backoff, the rate limiter
+// and, for batch and streaming sends, the adaptive limiter, and going
+// through the circuit breaker if configured.
+func (c *Client) send(ctx context.Context, accessToken, target string, p *payload) (*Response, error) {
+	if c.throttle != nil {
+		if err := c.throttle.wait(ctx, target); err != nil {
+			return nil, err
+		}
+	}
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx, laneFromContext(ctx)); err != nil {
+			return nil, err
+		}
+	}
+	bs := batchSendFromContext(ctx)
+	adaptive := bs != nil && c.adaptive != nil
+	var start time.Time
+	if adaptive {
+		var err error
+		if start, err = c.adaptive.acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var generation uint64
+	if c.breaker != nil {
+		var err error
+		if generation, err = c.breaker.allow(); err != nil {
+			if adaptive {
+				c.adaptive.abandon()
+			}
+			return nil, err
+		}
+	}
+	if bs != nil {
+		bs.started = true
+	}
+
+	resp, err := c.do(ctx, accessToken, p)
+
+	if adaptive {
+		c.adaptive.release(start, err)
+	}
+	if c.breaker != nil {
+		c.breaker.record(generation, err)
+	}
+	if c.throttle != nil {
+		c.throttle.record(target, err)
+	}
+	return resp, err
+}
+
+// do sends a request.
+func (c *Client) do(ctx context.Context, accessToken string, p *payload) (*Response, error) {
 	// create request
-	req, err := http.NewRequest("POST", c.endpoint, bytes.NewBuffer(data))
+	req, err := http.NewRequest("POST", c.endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Body = p.body()
+	req.ContentLength = int64(p.len())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return p.body(), nil
+	}
 
 	req = req.WithContext(ctx)
 
@@ -127,13 +296,17 @@ func (c *Client) send(ctx context.Context, accessToken string, data []byte) (*Re
 	req.Header.Add("Content-Type", "application/json")
 
 	// execute request
+	start := time.Now()
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, connectionError(err.Error())
+		return nil, connectionError{err}
 	}
 	defer resp.Body.Close()
 
 	if err := handleFCMResponse(resp); err != nil {
+		if fcmErr, ok := err.(*Error); ok {
+			fcmErr.Metadata = responseMetadata(resp, time.Since(start))
+		}
 		return nil, err
 	}
 
@@ -143,26 +316,56 @@ func (c *Client) send(ctx context.Context, accessToken string, data []byte) (*Re
 		return nil, err
 	}
 
+	if c.budget != nil {
+		c.budget.deposit()
+	}
+
+	response.Metadata = responseMetadata(resp, time.Since(start))
 	return response, nil
 }
 
+// responseMetadata returns the metadata of a single attempt.
+func responseMetadata(resp *http.Response, latency time.Duration) ResponseMetadata {
+	return ResponseMetadata{
+		StatusCode:       resp.StatusCode,
+		Header:           resp.Header,
+		RequestID:        requestID(resp.Header),
+		Latency:          latency,
+		AttemptLatencies: []time.Duration{latency},
+		Attempts:         1,
+	}
+}
+
 // handleFCMResponse processes the HTTP response.
 // For a 200 OK response, it does nothing.
-// For error responses, it decodes the error JSON (which follows the google.rpc.Status format)
-// and returns an error containing the status and message.
+// For error responses, it returns an *Error holding the status code, the
+// Retry-After delay and a snippet of the raw body. The Retry-After header
+// takes precedence over a RetryInfo detail. When the body is an error
+// JSON (which follows the google.rpc.Status format), the decoded status,
+// error code and message are included as well.
 func handleFCMResponse(resp *http.Response) error {
 	if resp.StatusCode == http.StatusOK {
 		return nil
 	}
 
-	if resp.StatusCode >= http.StatusInternalServerError {
-		return serverError(fmt.Sprintf("%d error: %s", resp.StatusCode, resp.Status))
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	fcmErr := &Error{
+		HTTPStatus: resp.StatusCode,
+		Code:       ErrorCodeUnspecifiedError,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Body:       bodySnippet(body),
 	}
 
 	var response Response
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return fmt.Errorf("failed to decode error response: %w", err)
+	if err := json.Unmarshal(body, &response); err == nil && response.Error != nil {
+		rpcErr := response.Err().(*Error)
+		fcmErr.Status = rpcErr.Status
+		fcmErr.Code = rpcErr.Code
+		fcmErr.Message = rpcErr.Message
+		fcmErr.Details = rpcErr.Details
+		if fcmErr.RetryAfter == 0 {
+			fcmErr.RetryAfter = rpcErr.RetryAfter
+		}
 	}
-
-	return response.Err()
+	return fcmErr
 }
@@ -2,6 +2,7 @@ package fcm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -39,6 +40,9 @@ func TestSend(t *testing.T) {
 		if resp.Name != expectedName {
 			t.Fatalf("expected name: %s, got: %s", expectedName, resp.Name)
 		}
+		if resp.Metadata.Attempts != 1 || resp.Metadata.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected metadata: %+v", resp.Metadata)
+		}
 	})
 
 	t.Run("send=failure", func(t *testing.T) {
@@ -75,6 +79,80 @@ func TestSend(t *testing.T) {
 		}
 	})
 
+	t.Run("send=typed_error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `{
+				"error": {
+					"code": 404,
+					"message": "Requested entity was not found.",
+					"status": "NOT_FOUND",
+					"details": [{
+						"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError",
+						"errorCode": "UNREGISTERED"
+					}]
+				}
+			}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = client.Send(&NewMessage{Message{Token: "device"}}, "token")
+		if !errors.Is(err, ErrUnregistered) {
+			t.Fatalf("expected <%v> error, got: %v", ErrUnregistered, err)
+		}
+		var fcmErr *Error
+		if !errors.As(err, &fcmErr) || fcmErr.HTTPStatus != http.StatusNotFound {
+			t.Fatalf("expected *Error with HTTP status 404, got: %#v", err)
+		}
+	})
+
+	t.Run("send=non_json_error", func(t *testing.T) {
+		tests := []struct {
+			status    int
+			temporary bool
+		}{
+			{status: http.StatusBadRequest, temporary: false},
+			{status: http.StatusBadGateway, temporary: true},
+		}
+		for _, tt := range tests {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/html")
+				rw.Header().Set("Retry-After", "7")
+				rw.WriteHeader(tt.status)
+				fmt.Fprint(rw, `<html><body>proxy error</body></html>`)
+			}))
+
+			client, err := NewClient("test", WithEndpoint(server.URL))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = client.Send(&NewMessage{Message{Token: "device"}}, "token")
+			server.Close()
+
+			var fcmErr *Error
+			if !errors.As(err, &fcmErr) {
+				t.Fatalf("expected *Error, got: %#v", err)
+			}
+			if fcmErr.HTTPStatus != tt.status {
+				t.Errorf("expected HTTP status %d, got: %d", tt.status, fcmErr.HTTPStatus)
+			}
+			if fcmErr.RetryAfter != 7*time.Second {
+				t.Errorf("expected retry after 7s, got: %v", fcmErr.RetryAfter)
+			}
+			if fcmErr.Body != "<html><body>proxy error</body></html>" {
+				t.Errorf("unexpected body: %q", fcmErr.Body)
+			}
+			if fcmErr.Temporary() != tt.temporary {
+				t.Errorf("expected temporary to be %v for status %d", tt.temporary, tt.status)
+			}
+		}
+	})
+
 	t.Run("send=invalid_token", func(t *testing.T) {
 		_, err := NewClient("test", WithEndpoint(""))
 		if err == nil {
@@ -223,6 +301,199 @@ func TestSendWithRetry(t *testing.T) {
 		}
 	})
 
+	t.Run("send_with_retry=metadata", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			attempts++
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("X-Request-Id", fmt.Sprintf("req-%d", attempts))
+			if attempts < 2 {
+				rw.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(rw, `{"error": {"code": 500, "message": "Internal Server Error", "status": "INTERNAL"}}`)
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+			fmt.Fprint(rw, `{"name": "projects/test/messages/12345"}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp, err := client.SendWithRetry(&NewMessage{Message{Topic: "test"}}, "token", 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		meta := resp.Metadata
+		if meta.StatusCode != http.StatusOK {
+			t.Errorf("expected status code 200, got: %d", meta.StatusCode)
+		}
+		if meta.RequestID != "req-2" {
+			t.Errorf("expected request id req-2, got: %q", meta.RequestID)
+		}
+		if meta.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected response headers, got: %v", meta.Header)
+		}
+		if meta.Attempts != 2 || len(meta.AttemptLatencies) != 2 {
+			t.Fatalf("expected 2 attempts, got: %d (%v)", meta.Attempts, meta.AttemptLatencies)
+		}
+		if meta.Latency < meta.AttemptLatencies[0]+meta.AttemptLatencies[1]+minBackoff {
+			t.Errorf("expected total latency %v to include attempts and backoff", meta.Latency)
+		}
+	})
+
+	t.Run("send_with_retry=failure_metadata", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			attempts++
+			rw.Header().Set("X-Request-Id", fmt.Sprintf("req-%d", attempts))
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(rw, `{"error": {"code": 500, "message": "Internal Server Error", "status": "INTERNAL"}}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = client.SendWithRetry(&NewMessage{Message{Topic: "test"}}, "token", 1)
+		var fcmErr *Error
+		if !errors.As(err, &fcmErr) {
+			t.Fatalf("expected *Error, got: %v", err)
+		}
+
+		meta := fcmErr.Metadata
+		if meta.StatusCode != http.StatusInternalServerError || meta.RequestID != "req-2" {
+			t.Errorf("expected metadata of the last attempt, got: %d, %q", meta.StatusCode, meta.RequestID)
+		}
+		if meta.Attempts != 2 || len(meta.AttemptLatencies) != 2 || meta.Latency < meta.AttemptLatencies[1] {
+			t.Fatalf("expected 2 attempts, got: %d (%v), %v", meta.Attempts, meta.AttemptLatencies, meta.Latency)
+		}
+	})
+
+	t.Run("send_with_retry=policy", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			attempts++
+			rw.Header().Set("Content-Type", "application/json")
+			if attempts < 4 {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+			fmt.Fprint(rw, `{"name": "projects/test/messages/12345"}`)
+		}))
+		defer server.Close()
+
+		if _, err := NewClient("test", WithRetryPolicy(nil)); err == nil {
+			t.Fatal("expected error due to nil retry policy, got nil")
+		}
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithRetryPolicy(&ExponentialJitterPolicy{Base: time.Millisecond, Max: 5 * time.Millisecond}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		start := time.Now()
+		if _, err := client.SendWithRetry(&NewMessage{Message{Topic: "test"}}, "token", 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if attempts != 4 {
+			t.Fatalf("expected 4 attempts, got: %d", attempts)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected policy backoff to be used, took: %v", elapsed)
+		}
+	})
+
+	t.Run("send_with_retry=retry_after", func(t *testing.T) {
+		var attempts int
+		var last time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			attempts++
+			switch attempts {
+			case 1:
+				rw.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(rw, `{"error": {"code": 429, "message": "Quota exceeded", "status": "RESOURCE_EXHAUSTED", "details": [
+					{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"},
+					{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "0.3s"}
+				]}}`)
+			case 2:
+				if since := time.Since(last); since < 300*time.Millisecond {
+					t.Errorf("expected retry after 300ms, got: %v", since)
+				}
+				rw.Header().Set("Retry-After", "1")
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				if since := time.Since(last); since < time.Second {
+					t.Errorf("expected retry after 1s, got: %v", since)
+				}
+				rw.WriteHeader(http.StatusOK)
+				fmt.Fprint(rw, `{"name": "projects/test/messages/12345"}`)
+			}
+			last = time.Now()
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := client.SendWithRetry(&NewMessage{Message{Topic: "test"}}, "token", 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if attempts != 3 {
+			t.Fatalf("expected 3 attempts, got: %d", attempts)
+		}
+	})
+
+	t.Run("send_with_retry=hooks", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			attempts++
+			if attempts < 3 {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+			fmt.Fprint(rw, `{"name": "projects/test/messages/12345"}`)
+		}))
+		defer server.Close()
+
+		var events []RetryEvent
+		var outcomes []RetryOutcome
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithRetryPolicy(&ExponentialJitterPolicy{Base: time.Millisecond, Max: time.Millisecond}),
+			WithOnRetry(func(e RetryEvent) { events = append(events, e) }),
+			WithOnRetryOutcome(func(o RetryOutcome) { outcomes = append(outcomes, o) }),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := client.SendWithRetry(&NewMessage{Message{Token: "device"}}, "token", 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(events) != 2 {
+			t.Fatalf("expected 2 retry events, got: %+v", events)
+		}
+		for i, e := range events {
+			if e.Attempt != i+1 || e.Target != "token:device" || e.Action != ActionRetry || e.Err == nil {
+				t.Errorf("unexpected retry event: %+v", e)
+			}
+			if e.Backoff < 0 || e.Backoff > time.Millisecond {
+				t.Errorf("unexpected backoff: %v", e.Backoff)
+			}
+		}
+		if len(outcomes) != 1 || outcomes[0].Attempts != 3 || outcomes[0].Err != nil || outcomes[0].Target != "token:device" {
+			t.Fatalf("unexpected outcomes: %+v", outcomes)
+		}
+	})
+
 	t.Run("send_with_retry=failure_retry", func(t *testing.T) {
 		// Use a client with a very short timeout to force a connection error.
 		client, err := NewClient("test",
@@ -410,9 +681,41 @@ func TestSendWithRetryWithContext(t *testing.T) {
 		if attempts != 1 {
 			t.Fatalf("expected 1 attempt due to context timeout, got: %d attempts", attempts)
 		}
-		_, ok := err.(connectionError)
-		if !ok {
-			t.Fatalf("error is not of type connectionError, got: %T", err)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected error to match context.DeadlineExceeded, got: %v", err)
+		}
+		if !errors.Is(err, ErrInternal) {
+			t.Fatalf("expected error to wrap the last FCM error, got: %v", err)
+		}
+	})
+
+	t.Run("send_with_retry_with_context=cancel_backoff", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(rw, `{"error": {"code": 500, "message": "Internal Error", "status": "INTERNAL"}}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithRetryPolicy(&ExponentialJitterPolicy{Base: time.Minute, Max: time.Minute}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, err = client.SendWithRetryWithContext(ctx, &NewMessage{Message{Topic: "test"}}, "token", 4)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error to match context.Canceled, got: %v", err)
+		}
+		if !errors.Is(err, ErrInternal) {
+			t.Fatalf("expected error to wrap the last FCM error, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("expected backoff to end on cancellation, took: %v", elapsed)
 		}
 	})
 }
@@ -0,0 +1,175 @@
+package fcm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errTypeBadRequest   = "type.googleapis.com/google.rpc.BadRequest"
+	errTypeQuotaFailure = "type.googleapis.com/google.rpc.QuotaFailure"
+	errTypeRetryInfo    = "type.googleapis.com/google.rpc.RetryInfo"
+	errTypeErrorInfo    = "type.googleapis.com/google.rpc.ErrorInfo"
+	errTypeDebugInfo    = "type.googleapis.com/google.rpc.DebugInfo"
+	errTypeApnsError    = "type.googleapis.com/google.firebase.fcm.v1.ApnsError"
+)
+
+type (
+	// FcmErrorDetail is the google.firebase.fcm.v1.FcmError detail.
+	FcmErrorDetail struct {
+		ErrorCode string `json:"errorCode"`
+	}
+
+	// BadRequest is the google.rpc.BadRequest detail describing which fields
+	// of the request were invalid.
+	BadRequest struct {
+		FieldViolations []ResponseErrorFieldViolation `json:"fieldViolations"`
+	}
+
+	// QuotaFailure is the google.rpc.QuotaFailure detail describing which
+	// quotas were exceeded.
+	QuotaFailure struct {
+		Violations []QuotaViolation `json:"violations"`
+	}
+
+	// QuotaViolation describes a single exceeded quota.
+	QuotaViolation struct {
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+	}
+
+	// RetryInfo is the google.rpc.RetryInfo detail telling how long to wait
+	// before retrying the request.
+	RetryInfo struct {
+		RetryDelay time.Duration `json:"-"`
+	}
+
+	// ErrorInfo is the google.rpc.ErrorInfo detail describing the cause of
+	// the error.
+	ErrorInfo struct {
+		Reason   string            `json:"reason"`
+		Domain   string            `json:"domain"`
+		Metadata map[string]string `json:"metadata,omitempty"`
+	}
+
+	// DebugInfo is the google.rpc.DebugInfo detail.
+	DebugInfo struct {
+		StackEntries []string `json:"stackEntries,omitempty"`
+		Detail       string   `json:"detail"`
+	}
+
+	// ApnsError is the google.firebase.fcm.v1.ApnsError detail passing the
+	// error returned by APNs through.
+	ApnsError struct {
+		StatusCode int    `json:"statusCode"`
+		Reason     string `json:"reason"`
+	}
+)
+
+// UnmarshalJSON decodes the detail and, depending on its "@type", stores the
+// typed detail in Value. Details of unknown types are only kept in Raw.
+func (d *ResponseErrorDetail) UnmarshalJSON(data []byte) error {
+	type detailAlias ResponseErrorDetail
+	var temp detailAlias
+	raw := append(json.RawMessage(nil), data...)
+	if err := json.Unmarshal(data, &temp); err != nil {
+		// Keep a malformed detail as is rather than failing the whole error.
+		*d = ResponseErrorDetail{Raw: raw}
+		return nil
+	}
+	*d = ResponseErrorDetail(temp)
+	d.Raw = raw
+
+	var value interface{}
+	switch d.Type {
+	case errTypeFCMError:
+		value = &FcmErrorDetail{}
+	case errTypeBadRequest:
+		value = &BadRequest{}
+	case errTypeQuotaFailure:
+		value = &QuotaFailure{}
+	case errTypeRetryInfo:
+		value = &RetryInfo{}
+	case errTypeErrorInfo:
+		value = &ErrorInfo{}
+	case errTypeDebugInfo:
+		value = &DebugInfo{}
+	case errTypeApnsError:
+		value = &ApnsError{}
+	default:
+		return nil
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		// Malformed known detail, only Raw is available.
+		return nil
+	}
+	d.Value = value
+	return nil
+}
+
+// MarshalJSON encodes the detail as it was received.
+func (d ResponseErrorDetail) MarshalJSON() ([]byte, error) {
+	if len(d.Raw) > 0 {
+		return d.Raw, nil
+	}
+	type detailAlias ResponseErrorDetail
+	return json.Marshal(detailAlias(d))
+}
+
+// UnmarshalJSON decodes the retry delay which is encoded as a
+// google.protobuf.Duration, e.g. "1.5s".
+func (r *RetryInfo) UnmarshalJSON(data []byte) error {
+	var temp struct {
+		RetryDelay json.RawMessage `json:"retryDelay"`
+	}
+	if err := json.Unmarshal(data, &temp); err != nil {
+		return err
+	}
+	if len(temp.RetryDelay) == 0 {
+		return nil
+	}
+	delay, err := parseDuration(temp.RetryDelay)
+	if err != nil {
+		return err
+	}
+	r.RetryDelay = delay
+	return nil
+}
+
+// parseDuration parses a google.protobuf.Duration in either its string
+// ("1.5s") or object ({"seconds": 1, "nanos": 500000000}) form.
+func parseDuration(data []byte) (time.Duration, error) {
+	if bytes.HasPrefix(data, []byte("{")) {
+		var d struct {
+			Seconds json.Number `json:"seconds"`
+			Nanos   int64       `json:"nanos"`
+		}
+		if err := json.Unmarshal(data, &d); err != nil {
+			return 0, err
+		}
+		var seconds int64
+		if d.Seconds != "" {
+			var err error
+			if seconds, err = d.Seconds.Int64(); err != nil {
+				return 0, err
+			}
+		}
+		return time.Duration(seconds)*time.Second + time.Duration(d.Nanos), nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSuffix(s, "s"), 64)
+	if err != nil || !strings.HasSuffix(s, "s") || math.IsNaN(seconds) || math.IsInf(seconds, 0) ||
+		math.Abs(seconds) > float64(math.MaxInt64)/float64(time.Second) {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
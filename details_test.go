@@ -0,0 +1,149 @@
+package fcm
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalErrorDetails(t *testing.T) {
+	data := []byte(`{
+		"error": {
+			"code": 429,
+			"message": "Quota exceeded",
+			"status": "RESOURCE_EXHAUSTED",
+			"details": [
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"},
+				{"@type": "type.googleapis.com/google.rpc.QuotaFailure", "violations": [{"subject": "project", "description": "too many messages"}]},
+				{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1.500s"},
+				{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED", "domain": "googleapis.com", "metadata": {"service": "fcm.googleapis.com"}},
+				{"@type": "type.googleapis.com/google.rpc.DebugInfo", "detail": "debug"},
+				{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "message.token", "description": "invalid"}]},
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.ApnsError", "statusCode": 400, "reason": "BadDeviceToken"},
+				{"@type": "type.googleapis.com/example.Unknown", "foo": "bar"}
+			]
+		}
+	}`)
+
+	var response Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	details := response.Error.Details
+	if len(details) != 8 {
+		t.Fatalf("expected 8 details, got: %d", len(details))
+	}
+
+	if v, ok := details[0].Value.(*FcmErrorDetail); !ok || v.ErrorCode != ErrorCodeQuotaExceeded {
+		t.Errorf("unexpected fcm error detail: %#v", details[0].Value)
+	}
+	if v, ok := details[1].Value.(*QuotaFailure); !ok || len(v.Violations) != 1 || v.Violations[0].Subject != "project" {
+		t.Errorf("unexpected quota failure detail: %#v", details[1].Value)
+	}
+	if v, ok := details[2].Value.(*RetryInfo); !ok || v.RetryDelay != 1500*time.Millisecond {
+		t.Errorf("unexpected retry info detail: %#v", details[2].Value)
+	}
+	if v, ok := details[3].Value.(*ErrorInfo); !ok || v.Reason != "RATE_LIMIT_EXCEEDED" || v.Metadata["service"] != "fcm.googleapis.com" {
+		t.Errorf("unexpected error info detail: %#v", details[3].Value)
+	}
+	if v, ok := details[4].Value.(*DebugInfo); !ok || v.Detail != "debug" {
+		t.Errorf("unexpected debug info detail: %#v", details[4].Value)
+	}
+	if v, ok := details[5].Value.(*BadRequest); !ok || v.FieldViolations[0].Field != "message.token" {
+		t.Errorf("unexpected bad request detail: %#v", details[5].Value)
+	}
+	if details[5].FieldViolations[0].Field != "message.token" {
+		t.Errorf("expected field violations to be kept on the detail, got: %#v", details[5])
+	}
+	if v, ok := details[6].Value.(*ApnsError); !ok || v.StatusCode != 400 || v.Reason != "BadDeviceToken" {
+		t.Errorf("unexpected apns error detail: %#v", details[6].Value)
+	}
+	if details[7].Value != nil || !strings.Contains(string(details[7].Raw), `"foo": "bar"`) {
+		t.Errorf("expected unknown detail to be kept as raw JSON, got: %#v", details[7])
+	}
+
+	if !errors.Is(response.Err(), ErrQuotaExceeded) {
+		t.Errorf("expected quota exceeded error, got: %v", response.Err())
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected time.Duration
+		fail     bool
+	}{
+		{data: `"30s"`, expected: 30 * time.Second},
+		{data: `"0.25s"`, expected: 250 * time.Millisecond},
+		{data: `{"seconds": "2", "nanos": 500000000}`, expected: 2500 * time.Millisecond},
+		{data: `{"seconds": 3}`, expected: 3 * time.Second},
+		{data: `"30"`, fail: true},
+		{data: `"abc"`, fail: true},
+		{data: `"NaNs"`, fail: true},
+		{data: `"Infs"`, fail: true},
+		{data: `"1e300s"`, fail: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration([]byte(tt.data))
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.data, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.data, tt.expected, got)
+		}
+	}
+}
+
+func TestRetryInfoDelay(t *testing.T) {
+	data := []byte(`{
+		"error": {
+			"code": 503,
+			"status": "UNAVAILABLE",
+			"details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2s"}]
+		}
+	}`)
+	var response Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var fcmErr *Error
+	if !errors.As(response.Err(), &fcmErr) || fcmErr.RetryAfter != 2*time.Second {
+		t.Fatalf("expected retry after 2s, got: %#v", response.Err())
+	}
+	if Classify(fcmErr) != ActionRetryLater {
+		t.Fatalf("expected %v, got: %v", ActionRetryLater, Classify(fcmErr))
+	}
+}
+
+func TestMalformedErrorDetail(t *testing.T) {
+	data := []byte(`{
+		"error": {
+			"code": 400,
+			"status": "INVALID_ARGUMENT",
+			"details": [
+				{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "soon"},
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "INVALID_ARGUMENT"}
+			]
+		}
+	}`)
+	var response Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	detail := response.Error.Details[0]
+	if detail.Value != nil || !strings.Contains(string(detail.Raw), `"soon"`) {
+		t.Fatalf("expected malformed detail to be kept as raw JSON, got: %#v", detail)
+	}
+	if !errors.Is(response.Err(), ErrInvalidArgument) {
+		t.Fatalf("expected <%v> error, got: %v", ErrInvalidArgument, response.Err())
+	}
+}
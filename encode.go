@@ -0,0 +1,144 @@
+package fcm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBufferSize is the capacity above which payloads are not returned
+// to the pool, so that a few large messages don't pin memory.
+const maxPooledBufferSize = 64 << 10
+
+// sharedPrefix is how every encoded NewMessage starts, the target of a
+// shared payload is spliced right after it.
+var sharedPrefix = []byte(`{"message":{`)
+
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		p := new(payload)
+		p.enc = json.NewEncoder(&p.buf)
+		return p
+	},
+}
+
+// payload is an encoded request body, shared by the attempts of a send.
+// Payloads are pooled along with their buffer and encoder. A payload returns
+// to the pool once it and every body reading it are released, since the
+// HTTP transport may close a request body after the request returned.
+type payload struct {
+	buf  bytes.Buffer
+	enc  *json.Encoder
+	refs int32
+}
+
+// payloadBody reads a payload as a request body.
+type payloadBody struct {
+	bytes.Reader
+	p    *payload
+	once sync.Once
+}
+
+func (b *payloadBody) Close() error {
+	b.once.Do(b.p.release)
+	return nil
+}
+
+// getPayload returns an empty payload from the pool, holding one reference.
+func getPayload() *payload {
+	p := payloadPool.Get().(*payload)
+	p.refs = 1
+	return p
+}
+
+// encodePayload encodes msg into a pooled payload.
+func encodePayload(msg *NewMessage) (*payload, error) {
+	p := getPayload()
+	if err := p.enc.Encode(msg); err != nil {
+		// The buffer may hold a partial encoding, leave it to the GC.
+		return nil, err
+	}
+	return p, nil
+}
+
+// len returns the size of the payload.
+func (p *payload) len() int {
+	return p.buf.Len()
+}
+
+// body returns a new reader of the payload, it must be closed.
+func (p *payload) body() io.ReadCloser {
+	atomic.AddInt32(&p.refs, 1)
+	b := &payloadBody{p: p}
+	b.Reset(p.buf.Bytes())
+	return b
+}
+
+// release releases the reference of the owner of the payload.
+func (p *payload) release() {
+	if atomic.AddInt32(&p.refs, -1) != 0 || p.buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	p.buf.Reset()
+	payloadPool.Put(p)
+}
+
+// sharedPayload is a message encoded once without its target, for which the
+// payloads of many tokens are built by splicing the token in.
+type sharedPayload struct {
+	*payload
+}
+
+// encodeShared encodes msg, which must have no target, to be sent to many
+// tokens.
+func encodeShared(msg *NewMessage) (sharedPayload, error) {
+	if msg.Message.Token != "" || msg.Message.Topic != "" || msg.Message.Condition != "" {
+		return sharedPayload{}, ErrInvalidTarget
+	}
+	p, err := encodePayload(msg)
+	if err != nil {
+		return sharedPayload{}, err
+	}
+	if !bytes.HasPrefix(p.buf.Bytes(), sharedPrefix) {
+		p.release()
+		return sharedPayload{}, errors.New("unexpected message encoding")
+	}
+	return sharedPayload{p}, nil
+}
+
+// withToken returns the payload of the shared message sent to token. It is
+// the same as encoding the message with its Token set.
+func (s sharedPayload) withToken(token string) *payload {
+	rest := s.buf.Bytes()[len(sharedPrefix):]
+
+	p := getPayload()
+	buf := &p.buf
+	buf.Grow(len(sharedPrefix) + len(token) + len(rest) + 11)
+	buf.Write(sharedPrefix)
+	buf.WriteString(`"token":`)
+	writeJSONString(buf, token)
+	if rest[0] != '}' {
+		buf.WriteByte(',')
+	}
+	buf.Write(rest)
+	return p
+}
+
+// writeJSONString writes s as a JSON string. Registration tokens only hold
+// characters which need no escaping, the others go through encoding/json.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x80 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			b, _ := json.Marshal(s)
+			buf.Write(b)
+			return
+		}
+	}
+	buf.WriteByte('"')
+	buf.WriteString(s)
+	buf.WriteByte('"')
+}
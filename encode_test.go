@@ -0,0 +1,191 @@
+package fcm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	ttl := uint(60)
+	shared := Message{
+		Data:         map[string]interface{}{"foo": "<bar>"},
+		Notification: &Notification{Title: "title", Body: "body"},
+		TimeToLive:   &ttl,
+	}
+
+	t.Run("payload", func(t *testing.T) {
+		msg := &NewMessage{Message{Token: "token", Data: map[string]interface{}{"foo": "bar"}}}
+		p, err := encodePayload(msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected, _ := json.Marshal(msg)
+		if got := bytes.TrimSpace(p.buf.Bytes()); !bytes.Equal(got, expected) {
+			t.Fatalf("expected payload %s, got: %s", expected, got)
+		}
+
+		body := p.body()
+		p.release()
+		if p.refs != 1 {
+			t.Fatalf("expected the open body to hold the payload, got %d refs", p.refs)
+		}
+		data, _ := ioutil.ReadAll(body)
+		if int64(len(data)) != int64(p.len()) {
+			t.Fatalf("expected body of %d bytes, got: %d", p.len(), len(data))
+		}
+		body.Close()
+		body.Close()
+		if p.refs != 0 {
+			t.Fatalf("expected payload to be released, got %d refs", p.refs)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		for _, m := range []Message{shared, {}} {
+			s, err := encodeShared(&NewMessage{Message: m})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, token := range []string{"abc:123_-", `quo"te<&>`, "unicodeé "} {
+				m.Token = token
+				expected, _ := json.Marshal(&NewMessage{Message: m})
+				p := s.withToken(token)
+				if got := bytes.TrimSpace(p.buf.Bytes()); !bytes.Equal(got, expected) {
+					t.Errorf("expected payload %s, got: %s", expected, got)
+				}
+				p.release()
+			}
+			s.release()
+		}
+	})
+
+	t.Run("shared with target", func(t *testing.T) {
+		if _, err := encodeShared(&NewMessage{Message{Topic: "news"}}); err != ErrInvalidTarget {
+			t.Fatalf("expected <%v> error, got: %v", ErrInvalidTarget, err)
+		}
+	})
+
+	t.Run("multicast", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			var msg NewMessage
+			if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if msg.Message.Notification == nil || msg.Message.Notification.Title != "title" {
+				t.Errorf("expected shared payload, got: %+v", msg.Message)
+			}
+			fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mr, err := client.SendMulticast(context.Background(), "token", &MulticastMessage{
+			Tokens:  []string{"a", "b", "c"},
+			Message: shared,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i, r := range mr.Responses {
+			if r.Err != nil || r.Response.Name != "projects/test/messages/"+mr.Tokens[i] {
+				t.Fatalf("%d: unexpected response: %+v", i, r)
+			}
+		}
+	})
+}
+
+func benchmarkMessage() Message {
+	return Message{
+		Data:         map[string]interface{}{"id": "1234", "kind": "news"},
+		Notification: &Notification{Title: "Breaking news", Body: "Something happened"},
+		Android:      map[string]interface{}{"priority": "high"},
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	token := "dGVzdC10b2tlbjpBUEE5MWJIZXhhbXBsZV9yZWdpc3RyYXRpb25fdG9rZW4"
+
+	b.Run("marshal", func(b *testing.B) {
+		msg := &NewMessage{Message: benchmarkMessage()}
+		msg.Message.Token = token
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			_ = bytes.NewBuffer(data)
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		msg := &NewMessage{Message: benchmarkMessage()}
+		msg.Message.Token = token
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p, err := encodePayload(msg)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			p.release()
+		}
+	})
+
+	b.Run("spliced", func(b *testing.B) {
+		s, err := encodeShared(&NewMessage{Message: benchmarkMessage()})
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		defer s.release()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.withToken(token).release()
+		}
+	})
+}
+
+func BenchmarkSendPath(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient("test", WithEndpoint(server.URL), WithMaxConcurrency(1))
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	b.Run("single", func(b *testing.B) {
+		msg := &NewMessage{Message: benchmarkMessage()}
+		msg.Message.Token = "token"
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := client.SendWithContext(context.Background(), "token", msg); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	// An operation is a fan-out to 100 tokens.
+	b.Run("multicast", func(b *testing.B) {
+		mm := &MulticastMessage{Tokens: make([]string, 100), Message: benchmarkMessage()}
+		for i := range mm.Tokens {
+			mm.Tokens[i] = fmt.Sprintf("token-%d", i)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := client.SendMulticast(context.Background(), "token", mm); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package fcm
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxErrorBodySize is the number of bytes read from an error response.
+	maxErrorBodySize = 64 << 10
+
+	// maxErrorBodySnippet is the number of bytes of the raw error response
+	// kept in Error.Body.
+	maxErrorBodySnippet = 512
+)
+
+// Sentinel errors for every FCM error code, use them with errors.Is to check
+// the reason a send failed:
+//
+//	if errors.Is(err, fcm.ErrUnregistered) {
+//		// remove the token
+//	}
+//
+// They are only meant to be compared against and must not be modified.
+var (
+	ErrUnspecified      = &Error{Code: ErrorCodeUnspecifiedError}
+	ErrInvalidArgument  = &Error{Code: ErrorCodeInvalidArgument}
+	ErrUnregistered     = &Error{Code: ErrorCodeUnregistered}
+	ErrSenderIDMismatch = &Error{Code: ErrorCodeSenderIdMismatch}
+	ErrQuotaExceeded    = &Error{Code: ErrorCodeQuotaExceeded}
+	ErrUnavailable      = &Error{Code: ErrorCodeUnavailable}
+	ErrInternal         = &Error{Code: ErrorCodeInternal}
+	ErrThirdPartyAuth   = &Error{Code: ErrorCodeThirdPartyAuthError}
+)
+
+// Error is the error returned when the FCM server rejects a message.
+type Error struct {
+	// HTTPStatus is the HTTP status code of the response.
+	HTTPStatus int
+	// Status is the google.rpc status, e.g. "INVALID_ARGUMENT".
+	Status string
+	// Code is the FCM error code, one of the ErrorCode* constants.
+	Code string
+	// Message is the error message returned by the server.
+	Message string
+	// Details holds the error details returned by the server.
+	Details []ResponseErrorDetail
+	// RetryAfter is the delay requested by the server through the
+	// Retry-After header or a RetryInfo detail, zero if the server didn't
+	// ask for one. Callers retrying on their own should wait at least as long.
+	RetryAfter time.Duration
+	// Body is a snippet of the raw response body, which helps to diagnose
+	// responses that are not produced by FCM itself, e.g. a proxy HTML page.
+	Body string
+	// Metadata describes the HTTP exchange which produced the error and,
+	// when returned by SendWithRetry, every attempt made.
+	Metadata ResponseMetadata
+}
+
+// Error implements the error interface. The message is formatted as follows:
+//
+//	"FCM error (<status> | <errorCode>): <errorMessage>"
+func (e *Error) Error() string {
+	status, message := e.Status, e.Message
+	if status == "" && e.HTTPStatus != 0 {
+		status = fmt.Sprintf("%d %s", e.HTTPStatus, http.StatusText(e.HTTPStatus))
+	}
+	if message == "" {
+		message = e.Body
+	}
+	return fmt.Sprintf("FCM error (%s | %s): %s", status, e.Code, message)
+}
+
+// Temporary reports whether the error is caused by a server side failure,
+// i.e. a 5xx response, which is worth retrying.
+// Implements `net.Error` interface.
+func (e *Error) Temporary() bool {
+	return e.HTTPStatus >= http.StatusInternalServerError
+}
+
+// Timeout implements `net.Error` interface.
+func (e *Error) Timeout() bool {
+	return false
+}
+
+// Is reports whether target is an *Error with the same FCM error code. When
+// the server didn't return an FCM error code, the google.rpc status is
+// compared instead, since most codes share their name with a status. A
+// target without a code never matches.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Code == "" {
+		return false
+	}
+	if t.Code == e.Code {
+		return true
+	}
+	return e.Code == ErrorCodeUnspecifiedError && t.Code == e.Status
+}
+
+// parseRetryAfter parses the Retry-After header which holds either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// bodySnippet returns at most maxErrorBodySnippet bytes of body without
+// splitting a UTF-8 sequence.
+func bodySnippet(body []byte) string {
+	if len(body) <= maxErrorBodySnippet {
+		return strings.TrimSpace(string(body))
+	}
+	cut := maxErrorBodySnippet
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return strings.TrimSpace(string(body[:cut])) + "..."
+}
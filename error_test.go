@@ -0,0 +1,74 @@
+package fcm
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: 0},
+		{value: "120", expected: 2 * time.Minute},
+		{value: "-1", expected: 0},
+		{value: now.Add(30 * time.Second).Format(http.TimeFormat), expected: 30 * time.Second},
+		{value: now.Add(-30 * time.Second).Format(http.TimeFormat), expected: 0},
+		{value: "soon", expected: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestBodySnippet(t *testing.T) {
+	if got := bodySnippet([]byte("  short  ")); got != "short" {
+		t.Fatalf("expected short body to be kept, got: %q", got)
+	}
+
+	body := strings.Repeat("é", maxErrorBodySnippet)
+	got := bodySnippet([]byte(body))
+	if len(got) > maxErrorBodySnippet+len("...") {
+		t.Fatalf("expected snippet to be bounded, got %d bytes", len(got))
+	}
+	if !strings.HasSuffix(got, "é...") {
+		t.Fatalf("expected snippet to end on a rune boundary, got: %q", got[len(got)-8:])
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &Error{HTTPStatus: http.StatusBadGateway, Code: ErrorCodeUnspecifiedError, Body: "<html>"}
+	expected := "FCM error (502 Bad Gateway | UNSPECIFIED_ERROR): <html>"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+	if !err.Temporary() {
+		t.Fatal("expected 5xx error to be temporary")
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		err      *Error
+		target   error
+		expected bool
+	}{
+		{err: &Error{Code: ErrorCodeUnregistered}, target: ErrUnregistered, expected: true},
+		{err: &Error{Code: ErrorCodeUnspecifiedError, Status: ErrorCodeInvalidArgument}, target: ErrInvalidArgument, expected: true},
+		{err: &Error{Code: ErrorCodeInternal}, target: ErrUnregistered, expected: false},
+		{err: &Error{}, target: &Error{}, expected: false},
+		{err: &Error{Code: ErrorCodeInternal}, target: &Error{HTTPStatus: http.StatusInternalServerError}, expected: false},
+	}
+	for _, tt := range tests {
+		if got := errors.Is(tt.err, tt.target); got != tt.expected {
+			t.Errorf("%+v is %+v: expected %v, got %v", tt.err, tt.target, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package fcm
+
+// Sources reported by Explain for every effective field.
+const (
+	SourceNotification        = "notification"
+	SourceData                = "data"
+	SourceAndroidNotification = "android.notification"
+	SourceAndroidData         = "android.data"
+	SourceApnsAlert           = "apns.payload.aps.alert"
+	SourceApnsFcmOptions      = "apns.fcm_options"
+	SourceApnsPayload         = "apns.payload"
+	SourceWebpushNotification = "webpush.notification"
+	SourceWebpushData         = "webpush.data"
+)
+
+type (
+	// Explanation is the payload each platform receives once the HTTP v1 API
+	// has merged the top-level fields with the platform specific overrides.
+	Explanation struct {
+		Android PlatformPayload `json:"android"`
+		Apns    PlatformPayload `json:"apns"`
+		Webpush PlatformPayload `json:"webpush"`
+	}
+
+	// PlatformPayload holds the effective notification and data fields
+	// delivered to a single platform.
+	PlatformPayload struct {
+		Notification map[string]EffectiveField `json:"notification,omitempty"`
+		Data         map[string]EffectiveField `json:"data,omitempty"`
+	}
+
+	// EffectiveField is the value a device sees for a field together with the
+	// level of the message it came from, one of the Source* constants.
+	EffectiveField struct {
+		Value  interface{} `json:"value"`
+		Source string      `json:"source"`
+	}
+)
+
+// Explain computes the effective payload of msg per platform after the HTTP
+// v1 override rules are applied: platform specific notification fields win
+// over the top-level Notification ones, and a platform specific data map
+// replaces the top-level Data as a whole.
+//
+// Only the title, body and image of the top-level Notification are taken
+// into account, the remaining fields are not part of the HTTP v1 API.
+func Explain(msg *NewMessage) (*Explanation, error) {
+	if msg == nil {
+		return nil, ErrInvalidMessage
+	}
+	m := msg.Message
+
+	base := make(map[string]EffectiveField)
+	if n := m.Notification; n != nil {
+		setField(base, "title", n.Title, SourceNotification)
+		setField(base, "body", n.Body, SourceNotification)
+		setField(base, "image", n.Image, SourceNotification)
+	}
+	data := make(map[string]EffectiveField, len(m.Data))
+	for k, v := range m.Data {
+		setField(data, k, v, SourceData)
+	}
+
+	e := &Explanation{
+		Android: PlatformPayload{Notification: copyFields(base), Data: copyFields(data)},
+		Apns:    PlatformPayload{Notification: copyFields(base), Data: copyFields(data)},
+		Webpush: PlatformPayload{Notification: copyFields(base), Data: copyFields(data)},
+	}
+
+	// Android
+	mergeFields(e.Android.Notification, m.Android, SourceAndroidNotification, "notification")
+	replaceFields(&e.Android.Data, m.Android, SourceAndroidData, "data")
+
+	// APNs: the alert may be a plain string which is shown as the body.
+	if alert, ok := lookup(m.Apns, "payload", "aps", "alert"); ok {
+		if s, isString := alert.(string); isString {
+			delete(e.Apns.Notification, "title")
+			setField(e.Apns.Notification, "body", s, SourceApnsAlert)
+		} else {
+			mergeFields(e.Apns.Notification, m.Apns, SourceApnsAlert, "payload", "aps", "alert")
+		}
+	}
+	if image := lookupString(m.Apns, "fcm_options", "image"); image != "" {
+		setField(e.Apns.Notification, "image", image, SourceApnsFcmOptions)
+	}
+	if payload, ok := lookup(m.Apns, "payload"); ok {
+		for k, v := range toMap(payload) {
+			if k != "aps" {
+				setField(e.Apns.Data, k, v, SourceApnsPayload)
+			}
+		}
+	}
+
+	// Webpush
+	mergeFields(e.Webpush.Notification, m.Webpush, SourceWebpushNotification, "notification")
+	replaceFields(&e.Webpush.Data, m.Webpush, SourceWebpushData, "data")
+
+	return e, nil
+}
+
+// mergeFields overrides dst with every key of the map found at path in m.
+func mergeFields(dst map[string]EffectiveField, m map[string]interface{}, source string, path ...string) {
+	v, ok := lookup(m, path...)
+	if !ok {
+		return
+	}
+	for k, v := range toMap(v) {
+		setField(dst, k, v, source)
+	}
+}
+
+// replaceFields replaces dst with the map found at path in m, if any.
+func replaceFields(dst *map[string]EffectiveField, m map[string]interface{}, source string, path ...string) {
+	if _, ok := lookup(m, path...); !ok {
+		return
+	}
+	*dst = make(map[string]EffectiveField)
+	mergeFields(*dst, m, source, path...)
+}
+
+// setField stores a non-empty value in dst.
+func setField(dst map[string]EffectiveField, key string, value interface{}, source string) {
+	if s, ok := value.(string); (ok && s == "") || value == nil {
+		return
+	}
+	dst[key] = EffectiveField{Value: value, Source: source}
+}
+
+func copyFields(src map[string]EffectiveField) map[string]EffectiveField {
+	dst := make(map[string]EffectiveField, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// toMap converts the map types accepted by lookup to map[string]interface{}.
+func toMap(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return m
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package fcm
+
+import "testing"
+
+func TestExplain(t *testing.T) {
+	t.Run("overrides", func(t *testing.T) {
+		msg := &NewMessage{Message{
+			Token: "token",
+			Notification: &Notification{
+				Title: "title",
+				Body:  "body",
+				Image: "https://example.com/a.png",
+			},
+			Data: map[string]interface{}{"foo": "bar", "id": "1"},
+			Android: map[string]interface{}{
+				"notification": map[string]interface{}{"title": "android title", "channel_id": "news"},
+				"data":         map[string]string{"id": "2"},
+			},
+			Apns: map[string]interface{}{
+				"payload": map[string]interface{}{
+					"aps":    map[string]interface{}{"alert": map[string]interface{}{"body": "apns body"}},
+					"custom": "x",
+				},
+				"fcm_options": map[string]interface{}{"image": "https://example.com/b.png"},
+			},
+			Webpush: map[string]interface{}{
+				"notification": map[string]interface{}{"icon": "icon.png"},
+			},
+		}}
+
+		e, err := Explain(msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		tests := []struct {
+			name   string
+			fields map[string]EffectiveField
+			key    string
+			value  interface{}
+			source string
+		}{
+			{"android title", e.Android.Notification, "title", "android title", SourceAndroidNotification},
+			{"android body", e.Android.Notification, "body", "body", SourceNotification},
+			{"android channel", e.Android.Notification, "channel_id", "news", SourceAndroidNotification},
+			{"android data", e.Android.Data, "id", "2", SourceAndroidData},
+			{"apns title", e.Apns.Notification, "title", "title", SourceNotification},
+			{"apns body", e.Apns.Notification, "body", "apns body", SourceApnsAlert},
+			{"apns image", e.Apns.Notification, "image", "https://example.com/b.png", SourceApnsFcmOptions},
+			{"apns data", e.Apns.Data, "custom", "x", SourceApnsPayload},
+			{"webpush icon", e.Webpush.Notification, "icon", "icon.png", SourceWebpushNotification},
+			{"webpush image", e.Webpush.Notification, "image", "https://example.com/a.png", SourceNotification},
+			{"webpush data", e.Webpush.Data, "id", "1", SourceData},
+		}
+		if _, ok := e.Android.Data["foo"]; ok {
+			t.Error("expected android data to replace the top-level data")
+		}
+		for _, tt := range tests {
+			got, ok := tt.fields[tt.key]
+			if !ok {
+				t.Errorf("%s: field %q is missing", tt.name, tt.key)
+				continue
+			}
+			if got.Value != tt.value || got.Source != tt.source {
+				t.Errorf("%s: expected %v from %s, got %v from %s", tt.name, tt.value, tt.source, got.Value, got.Source)
+			}
+		}
+	})
+
+	t.Run("apns string alert", func(t *testing.T) {
+		e, err := Explain(&NewMessage{Message{
+			Token:        "token",
+			Notification: &Notification{Title: "title", Body: "body"},
+			Apns: map[string]interface{}{
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{"alert": "plain"},
+				},
+			},
+		}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := e.Apns.Notification["title"]; ok {
+			t.Error("expected title to be replaced by the string alert")
+		}
+		if got := e.Apns.Notification["body"]; got.Value != "plain" || got.Source != SourceApnsAlert {
+			t.Errorf("unexpected body: %+v", got)
+		}
+	})
+
+	t.Run("nil message", func(t *testing.T) {
+		if _, err := Explain(nil); err != ErrInvalidMessage {
+			t.Fatalf("expected <%v> error, got: %v", ErrInvalidMessage, err)
+		}
+	})
+}
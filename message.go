@@ -12,7 +12,8 @@ var (
 	// ErrInvalidTarget occurs if message topic is empty.
 	ErrInvalidTarget = errors.New("topic is invalid or registration ids are not set")
 
-	// ErrToManyRegIDs occurs when registration ids more then 1000.
+	// ErrToManyRegIDs occurs when a multicast message has more then 1000
+	// registration tokens.
 	ErrToManyRegIDs = errors.New("too many registrations ids")
 
 	// ErrInvalidTimeToLive occurs if TimeToLive more then 2419200.
@@ -53,6 +54,7 @@ type Message struct {
 	RestrictedPackageName string                 `json:"restricted_package_name,omitempty"`
 	Notification          *Notification          `json:"notification,omitempty"`
 	Data                  map[string]interface{} `json:"data,omitempty"`
+	Android               map[string]interface{} `json:"android,omitempty"`
 	Apns                  map[string]interface{} `json:"apns,omitempty"`
 	Webpush               map[string]interface{} `json:"webpush,omitempty"`
 }
@@ -78,3 +80,36 @@ func (msg *NewMessage) Validate() error {
 	}
 	return nil
 }
+
+// lookup walks nested maps along path and returns the value found at its end.
+// Intermediate values may be either map[string]interface{} or map[string]string
+// since both are commonly used to build platform specific configs.
+func lookup(m map[string]interface{}, path ...string) (interface{}, bool) {
+	var cur interface{} = m
+	for _, key := range path {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case map[string]string:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		default:
+			return nil, false
+		}
+	}
+	return cur, cur != nil
+}
+
+// lookupString returns the string value found at path, if any.
+func lookupString(m map[string]interface{}, path ...string) string {
+	v, _ := lookup(m, path...)
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,121 @@
+package fcm
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidMessageID occurs if a message name is not of the form
+// `projects/<project>/messages/<id>`.
+var ErrInvalidMessageID = errors.New("message id is invalid")
+
+// MessageID identifies a message accepted by FCM. It is parsed from the
+// fully qualified message name returned in Response.Name.
+//
+// MessageID implements text and JSON marshaling as well as sql.Scanner and
+// driver.Valuer, it is encoded as the fully qualified name.
+type MessageID struct {
+	project string
+	id      string
+}
+
+// ParseMessageID parses a message name of the form
+// `projects/<project>/messages/<id>`.
+func ParseMessageID(name string) (MessageID, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "messages" || parts[1] == "" || parts[3] == "" {
+		return MessageID{}, fmt.Errorf("%w: %q", ErrInvalidMessageID, name)
+	}
+	return MessageID{project: parts[1], id: parts[3]}, nil
+}
+
+// MessageID returns the ID of the message parsed from r.Name.
+func (r *Response) MessageID() (MessageID, error) {
+	return ParseMessageID(r.Name)
+}
+
+// Project returns the ID of the Firebase project the message was sent from.
+func (m MessageID) Project() string {
+	return m.project
+}
+
+// ID returns the ID of the message within its project.
+func (m MessageID) ID() string {
+	return m.id
+}
+
+// IsZero reports whether m is the zero MessageID.
+func (m MessageID) IsZero() bool {
+	return m.project == "" && m.id == ""
+}
+
+// String returns the fully qualified message name.
+func (m MessageID) String() string {
+	if m.IsZero() {
+		return ""
+	}
+	return "projects/" + m.project + "/messages/" + m.id
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m MessageID) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text decodes to
+// the zero MessageID.
+func (m *MessageID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*m = MessageID{}
+		return nil
+	}
+	id, err := ParseMessageID(string(text))
+	if err != nil {
+		return err
+	}
+	*m = id
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m MessageID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *MessageID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = MessageID{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return m.UnmarshalText([]byte(s))
+}
+
+// Value implements driver.Valuer. The zero MessageID is stored as NULL.
+func (m MessageID) Value() (driver.Value, error) {
+	if m.IsZero() {
+		return nil, nil
+	}
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *MessageID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = MessageID{}
+		return nil
+	case string:
+		return m.UnmarshalText([]byte(v))
+	case []byte:
+		return m.UnmarshalText(v)
+	}
+	return fmt.Errorf("cannot scan %T into MessageID", src)
+}
@@ -0,0 +1,98 @@
+package fcm
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMessageID(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		resp := &Response{Name: "projects/myproject/messages/0:1500415314455276%31bd1c9631bd1c96"}
+		id, err := resp.MessageID()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id.Project() != "myproject" {
+			t.Errorf("expected project myproject, got: %s", id.Project())
+		}
+		if id.ID() != "0:1500415314455276%31bd1c9631bd1c96" {
+			t.Errorf("unexpected id: %s", id.ID())
+		}
+		if id.String() != resp.Name {
+			t.Errorf("expected %s, got: %s", resp.Name, id.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, name := range []string{
+			"",
+			"12345",
+			"projects/myproject/messages/",
+			"projects//messages/12345",
+			"projects/myproject/topics/12345",
+			"projects/myproject/messages/12345/extra",
+		} {
+			if _, err := ParseMessageID(name); !errors.Is(err, ErrInvalidMessageID) {
+				t.Errorf("%q: expected <%v> error, got: %v", name, ErrInvalidMessageID, err)
+			}
+		}
+	})
+}
+
+func TestMessageIDMarshaling(t *testing.T) {
+	id, err := ParseMessageID("projects/p/messages/42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("json", func(t *testing.T) {
+		type record struct {
+			ID    MessageID `json:"id"`
+			Empty MessageID `json:"empty"`
+		}
+		data, err := json.Marshal(record{ID: id})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != `{"id":"projects/p/messages/42","empty":""}` {
+			t.Fatalf("unexpected JSON: %s", data)
+		}
+
+		var got record
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.ID != id || !got.Empty.IsZero() {
+			t.Fatalf("unexpected record: %+v", got)
+		}
+
+		if err := json.Unmarshal([]byte(`{"id":"bogus"}`), &got); !errors.Is(err, ErrInvalidMessageID) {
+			t.Fatalf("expected <%v> error, got: %v", ErrInvalidMessageID, err)
+		}
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		v, err := id.Value()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got MessageID
+		if err := got.Scan([]byte(v.(string))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != id {
+			t.Fatalf("expected %v, got: %v", id, got)
+		}
+
+		if v, _ := (MessageID{}).Value(); v != nil {
+			t.Fatalf("expected NULL for zero id, got: %v", v)
+		}
+		if err := got.Scan(nil); err != nil || !got.IsZero() {
+			t.Fatalf("expected zero id from NULL, got: %v (%v)", got, err)
+		}
+		if err := got.Scan(42); err == nil {
+			t.Fatal("expected error scanning an int")
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package fcm
+
+import "context"
+
+// maxMulticastTokens is the maximum number of tokens of a MulticastMessage.
+const maxMulticastTokens = 1000
+
+// MulticastMessage is a message sent to a list of registration tokens. The
+// HTTP v1 API has no multicast, the message is sent to every token on its
+// own with the shared payload.
+type MulticastMessage struct {
+	// Tokens are the registration tokens to send to, none may be empty.
+	Tokens []string
+	// Message is the payload shared by all tokens, its Token, Topic and
+	// Condition must be empty.
+	Message Message
+}
+
+// MulticastResponse holds the results of a multicast send, Responses are in
+// the order of Tokens.
+type MulticastResponse struct {
+	BatchResponse
+	Tokens []string
+}
+
+// Validate returns an error if the multicast message is not well-formed.
+func (mm *MulticastMessage) Validate() error {
+	if mm == nil {
+		return ErrInvalidMessage
+	}
+	if len(mm.Tokens) == 0 || mm.Message.Token != "" || mm.Message.Topic != "" || mm.Message.Condition != "" {
+		return ErrInvalidTarget
+	}
+	if len(mm.Tokens) > maxMulticastTokens {
+		return ErrToManyRegIDs
+	}
+	for _, token := range mm.Tokens {
+		if token == "" {
+			return ErrInvalidTarget
+		}
+	}
+	return nil
+}
+
+// message returns the message sent to token.
+func (mm *MulticastMessage) message(token string) *NewMessage {
+	msg := &NewMessage{Message: mm.Message}
+	msg.Message.Token = token
+	return msg
+}
+
+// SendMulticast sends the message to every token of mm, like SendEach does.
+// The results are aligned with mm.Tokens. The message is validated and the
+// shared payload is encoded once, only the token differs between the
+// requests.
+func (c *Client) SendMulticast(ctx context.Context, accessToken string, mm *MulticastMessage) (*MulticastResponse, error) {
+	if err := mm.Validate(); err != nil {
+		return nil, err
+	}
+	// The target aside, every message is the same and validates the same.
+	msg := mm.message(mm.Tokens[0])
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+
+	shared, err := encodeShared(&NewMessage{Message: mm.Message})
+	if err != nil {
+		return nil, err
+	}
+	defer shared.release()
+
+	br := c.sendBatch(ctx, len(mm.Tokens), func(ctx context.Context, i int) (*Response, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		p := shared.withToken(mm.Tokens[i])
+		defer p.release()
+		resp, err := c.send(ctx, accessToken, tokenTarget(mm.Tokens[i]), p)
+		if err != nil {
+			return nil, err
+		}
+		c.recordSent(msg)
+		return resp, nil
+	})
+	return &MulticastResponse{BatchResponse: *br, Tokens: mm.Tokens}, nil
+}
+
+// PermanentFailures returns the tokens the message can't be delivered to by
+// retrying, e.g. unregistered tokens or invalid payloads. Use Classify on the
+// matching error to tell which.
+func (r *MulticastResponse) PermanentFailures() []string {
+	return r.failures(false)
+}
+
+// TransientFailures returns the tokens the message may still be delivered
+// to by retrying later, including the canceled ones.
+func (r *MulticastResponse) TransientFailures() []string {
+	return r.failures(true)
+}
+
+func (r *MulticastResponse) failures(transient bool) []string {
+	var tokens []string
+	for i, resp := range r.Responses {
+		if resp.Success() {
+			continue
+		}
+		if isTransient(resp) == transient {
+			tokens = append(tokens, r.Tokens[i])
+		}
+	}
+	return tokens
+}
+
+// isTransient reports whether the failed send may succeed when retried.
+func isTransient(resp SendResponse) bool {
+	if resp.Canceled {
+		return true
+	}
+	switch Classify(resp.Err) {
+	case ActionRetry, ActionRetryLater:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSendMulticast(t *testing.T) {
+	t.Run("send_multicast=results", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			var msg NewMessage
+			if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if msg.Message.Data["foo"] != "bar" {
+				t.Errorf("expected shared payload, got: %+v", msg.Message)
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			switch msg.Message.Token {
+			case "gone":
+				rw.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(rw, `{"error": {"code": 404, "status": "NOT_FOUND", "details": [
+					{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}
+				]}}`)
+			case "busy":
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(rw, `{"error": {"code": 503, "status": "UNAVAILABLE"}}`)
+			default:
+				fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+			}
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mm := &MulticastMessage{
+			Tokens:  []string{"a", "gone", "b", "busy", "c"},
+			Message: Message{Data: map[string]interface{}{"foo": "bar"}},
+		}
+		resp, err := client.SendMulticast(context.Background(), "token", mm)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.SuccessCount != 3 || resp.FailureCount != 2 {
+			t.Fatalf("unexpected counts: %+v", resp.BatchResponse)
+		}
+		for i, token := range mm.Tokens {
+			r := resp.Responses[i]
+			if r.Success() && r.Response.Name != "projects/test/messages/"+token {
+				t.Errorf("%d: response is not aligned with token %s: %s", i, token, r.Response.Name)
+			}
+		}
+		if got := resp.PermanentFailures(); !reflect.DeepEqual(got, []string{"gone"}) {
+			t.Errorf("unexpected permanent failures: %v", got)
+		}
+		if got := resp.TransientFailures(); !reflect.DeepEqual(got, []string{"busy"}) {
+			t.Errorf("unexpected transient failures: %v", got)
+		}
+	})
+
+	t.Run("send_multicast=invalid", func(t *testing.T) {
+		client, err := NewClient("test")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tests := []struct {
+			mm       *MulticastMessage
+			expected error
+		}{
+			{nil, ErrInvalidMessage},
+			{&MulticastMessage{}, ErrInvalidTarget},
+			{&MulticastMessage{Tokens: []string{"a"}, Message: Message{Topic: "news"}}, ErrInvalidTarget},
+			{&MulticastMessage{Tokens: []string{"a", ""}}, ErrInvalidTarget},
+			{&MulticastMessage{Tokens: []string{"", "a"}}, ErrInvalidTarget},
+			{&MulticastMessage{Tokens: make([]string, maxMulticastTokens+1)}, ErrToManyRegIDs},
+		}
+		for _, tt := range tests {
+			if _, err := client.SendMulticast(context.Background(), "token", tt.mm); err != tt.expected {
+				t.Errorf("expected <%v> error, got: %v", tt.expected, err)
+			}
+		}
+	})
+
+	t.Run("send_multicast=registry", func(t *testing.T) {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requests++
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL), WithRegistry(NewRegistry()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp, err := client.SendMulticast(context.Background(), "token", &MulticastMessage{
+			Tokens:  []string{"a", "b", "c"},
+			Message: Message{Notification: &Notification{ChannelID: "news"}},
+		})
+		if !errors.Is(err, ErrUnknownChannel) || resp != nil {
+			t.Fatalf("expected <%v> error, got: %v", ErrUnknownChannel, err)
+		}
+		if requests != 0 {
+			t.Fatalf("expected no request, got: %d", requests)
+		}
+	})
+}
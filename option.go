@@ -2,6 +2,7 @@ package fcm
 
 import (
 	"errors"
+	"math"
 	"net/http"
 	"time"
 )
@@ -20,7 +21,8 @@ func WithEndpoint(endpoint string) Option {
 	}
 }
 
-// WithHTTPClient returns Option to configure HTTP Client.
+// WithHTTPClient returns Option to configure HTTP Client. It can't be used
+// together with WithTransport.
 func WithHTTPClient(httpClient *http.Client) Option {
 	return func(c *Client) error {
 		c.client = httpClient
@@ -28,6 +30,18 @@ func WithHTTPClient(httpClient *http.Client) Option {
 	}
 }
 
+// WithTransport returns Option to adjust the HTTP transport tuned for FCM
+// used by default. It can't be used together with WithHTTPClient.
+func WithTransport(cfg TransportConfig) Option {
+	return func(c *Client) error {
+		if cfg.MaxIdleConnsPerHost < 0 || cfg.MaxConnsPerHost < 0 || cfg.TLSSessionCacheSize < 0 {
+			return errors.New("invalid transport config")
+		}
+		c.transport = &cfg
+		return nil
+	}
+}
+
 // WithTimeout returns Option to configure HTTP Client timeout.
 func WithTimeout(d time.Duration) Option {
 	return func(c *Client) error {
@@ -38,3 +52,145 @@ func WithTimeout(d time.Duration) Option {
 		return nil
 	}
 }
+
+// WithRegistry returns Option to validate every message against the declared
+// Android notification channels and APNs categories before sending it.
+func WithRegistry(r *Registry) Option {
+	return func(c *Client) error {
+		if r == nil {
+			return errors.New("invalid registry")
+		}
+		c.registry = r
+		return nil
+	}
+}
+
+// WithRetryPolicy returns Option to configure the backoff between retries of
+// SendWithRetry. By default the backoff grows quadratically without jitter.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) error {
+		if policy == nil {
+			return errors.New("invalid retry policy")
+		}
+		c.policy = policy
+		return nil
+	}
+}
+
+// WithRetryBudget returns Option to shed retries of SendWithRetry once they
+// exceed the given budget. The budget is shared by all sends of the Client.
+func WithRetryBudget(budget *RetryBudget) Option {
+	return func(c *Client) error {
+		if budget == nil {
+			return errors.New("invalid retry budget")
+		}
+		c.budget = budget
+		return nil
+	}
+}
+
+// WithCircuitBreaker returns Option to fast-fail sends with ErrCircuitOpen
+// while the FCM endpoint is failing.
+func WithCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(c *Client) error {
+		if cb == nil {
+			return errors.New("invalid circuit breaker")
+		}
+		c.breaker = cb
+		return nil
+	}
+}
+
+// WithTargetBackoff returns Option to back off the devices and topics for
+// which FCM returned QUOTA_EXCEEDED, without slowing down other targets.
+func WithTargetBackoff(cfg TargetBackoffConfig) Option {
+	return func(c *Client) error {
+		if cfg.MaxWait < 0 {
+			return errors.New("invalid target backoff max wait")
+		}
+		c.throttle = newTargetThrottle(cfg)
+		return nil
+	}
+}
+
+// WithOnRetry returns Option to observe every retry made by SendWithRetry,
+// e.g. to log or count them. The callback is called synchronously before
+// waiting for the backoff and must not block.
+func WithOnRetry(fn func(RetryEvent)) Option {
+	return func(c *Client) error {
+		if fn == nil {
+			return errors.New("invalid retry callback")
+		}
+		c.onRetry = fn
+		return nil
+	}
+}
+
+// WithOnRetryOutcome returns Option to observe the final outcome of every
+// SendWithRetry, after all of its attempts.
+func WithOnRetryOutcome(fn func(RetryOutcome)) Option {
+	return func(c *Client) error {
+		if fn == nil {
+			return errors.New("invalid retry outcome callback")
+		}
+		c.onOutcome = fn
+		return nil
+	}
+}
+
+// WithMaxConcurrency returns Option to configure the number of messages sent
+// in parallel by the batch send methods.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return errors.New("invalid max concurrency")
+		}
+		c.concurrency = n
+		return nil
+	}
+}
+
+// WithRateLimit returns Option to limit the requests sent to FCM to rate per
+// second with bursts of up to burst requests, e.g. a quota of 600,000
+// messages per minute is a rate of 10,000. The limit applies to every send
+// method and retry; sends wait for capacity until their context is done.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *Client) error {
+		if !(rate > 0) || math.IsInf(rate, 1) {
+			return errors.New("invalid rate limit")
+		}
+		if burst < 1 {
+			return errors.New("invalid rate limit burst")
+		}
+		c.limiter = newRateLimiter(rate, burst)
+		return nil
+	}
+}
+
+// WithReservedCapacity returns Option to reserve a fraction of the rate
+// limit burst to transactional sends: sends made with a context returned by
+// WithLane(ctx, LaneBulk) only go through while more capacity is left. At
+// least one request of the burst is left to bulk sends. It requires
+// WithRateLimit.
+func WithReservedCapacity(fraction float64) Option {
+	return func(c *Client) error {
+		if !(fraction >= 0 && fraction < 1) {
+			return errors.New("invalid reserved capacity")
+		}
+		c.reserved = fraction
+		return nil
+	}
+}
+
+// WithAdaptiveConcurrency returns Option to adapt the number of batch and
+// streaming sends in flight to the health of FCM, instead of the fixed
+// WithMaxConcurrency.
+func WithAdaptiveConcurrency(l *AdaptiveLimiter) Option {
+	return func(c *Client) error {
+		if l == nil {
+			return errors.New("invalid adaptive limiter")
+		}
+		c.adaptive = l
+		return nil
+	}
+}
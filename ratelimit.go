@@ -0,0 +1,111 @@
+package fcm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Lane is the priority class of a send when the Client is rate limited, see
+// WithRateLimit and WithLane.
+type Lane int
+
+const (
+	// LaneTransactional is the default lane. It may use the whole capacity of
+	// the rate limit, including the reserved part.
+	LaneTransactional Lane = iota
+	// LaneBulk is the lane of batch jobs. It can't use the capacity reserved
+	// for transactional sends, see WithReservedCapacity.
+	LaneBulk
+)
+
+type laneKey struct{}
+
+// WithLane returns a context making the sends using it go through the given
+// lane of the rate limiter.
+func WithLane(ctx context.Context, lane Lane) context.Context {
+	return context.WithValue(ctx, laneKey{}, lane)
+}
+
+// laneFromContext returns the lane set with WithLane, LaneTransactional if
+// none is.
+func laneFromContext(ctx context.Context) Lane {
+	lane, _ := ctx.Value(laneKey{}).(Lane)
+	return lane
+}
+
+// rateLimiter is a token bucket limiting the requests sent to FCM. A part of
+// the bucket can be reserved to the transactional lane: bulk sends only go
+// through while the bucket holds more tokens than the reserve.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	reserve float64
+	tokens  float64
+	last    time.Time
+	now     func() time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	l.last = l.now()
+	return l
+}
+
+// setReserved reserves a fraction of the burst to the transactional lane. The
+// reserve is capped so that a full bucket always lets a bulk send through.
+func (l *rateLimiter) setReserved(fraction float64) {
+	l.mu.Lock()
+	l.reserve = fraction * l.burst
+	if l.reserve > l.burst-1 {
+		l.reserve = l.burst - 1
+	}
+	l.mu.Unlock()
+}
+
+// wait blocks until a request of the given lane may be sent or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, lane Lane) error {
+	for {
+		delay := l.reserveToken(lane)
+		if delay == 0 {
+			return nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserveToken takes a token and returns zero, or returns how long to wait
+// until one is available to lane.
+func (l *rateLimiter) reserveToken(lane Lane) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	need := 1.0
+	if lane == LaneBulk {
+		need += l.reserve
+	}
+	if l.tokens >= need {
+		l.tokens--
+		return 0
+	}
+	delay := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+	if delay <= 0 {
+		delay = time.Nanosecond
+	}
+	return delay
+}
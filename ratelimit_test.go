@@ -0,0 +1,136 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	newLimiter := func(rate float64, burst int) (*rateLimiter, *time.Time) {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		l := newRateLimiter(rate, burst)
+		l.now = func() time.Time { return now }
+		l.last = now
+		return l, &now
+	}
+
+	t.Run("burst and refill", func(t *testing.T) {
+		l, now := newLimiter(10, 3)
+		for i := 0; i < 3; i++ {
+			if d := l.reserveToken(LaneTransactional); d != 0 {
+				t.Fatalf("%d: expected token within burst, got delay %v", i, d)
+			}
+		}
+		if d := l.reserveToken(LaneTransactional); d != 100*time.Millisecond {
+			t.Fatalf("expected delay of 100ms, got: %v", d)
+		}
+		*now = now.Add(100 * time.Millisecond)
+		if d := l.reserveToken(LaneTransactional); d != 0 {
+			t.Fatalf("expected refilled token, got delay %v", d)
+		}
+	})
+
+	t.Run("reserved lane", func(t *testing.T) {
+		l, _ := newLimiter(10, 10)
+		l.setReserved(0.5)
+		for i := 0; i < 5; i++ {
+			if d := l.reserveToken(LaneBulk); d != 0 {
+				t.Fatalf("%d: expected bulk token, got delay %v", i, d)
+			}
+		}
+		if d := l.reserveToken(LaneBulk); d == 0 {
+			t.Fatal("expected bulk lane not to use the reserved capacity")
+		}
+		for i := 0; i < 5; i++ {
+			if d := l.reserveToken(LaneTransactional); d != 0 {
+				t.Fatalf("%d: expected transactional token, got delay %v", i, d)
+			}
+		}
+	})
+
+	t.Run("reserve cap", func(t *testing.T) {
+		for _, tt := range []struct {
+			burst    int
+			fraction float64
+		}{{1, 0.5}, {4, 0.9}} {
+			l, _ := newLimiter(1000, tt.burst)
+			l.setReserved(tt.fraction)
+			if d := l.reserveToken(LaneBulk); d != 0 {
+				t.Fatalf("burst %d, reserve %v: expected bulk send on a full bucket, got delay %v", tt.burst, tt.fraction, d)
+			}
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		l := newRateLimiter(0.001, 1)
+		if err := l.wait(context.Background(), LaneTransactional); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := l.wait(ctx, LaneTransactional); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context error, got: %v", err)
+		}
+	})
+
+	t.Run("lane context", func(t *testing.T) {
+		if laneFromContext(context.Background()) != LaneTransactional {
+			t.Fatal("expected transactional lane by default")
+		}
+		if laneFromContext(WithLane(context.Background(), LaneBulk)) != LaneBulk {
+			t.Fatal("expected bulk lane from context")
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		defer server.Close()
+
+		for _, rate := range []float64{0, math.NaN(), math.Inf(1), math.Inf(-1)} {
+			if _, err := NewClient("test", WithRateLimit(rate, 1)); err == nil {
+				t.Fatalf("expected error due to invalid rate %v, got nil", rate)
+			}
+		}
+		for _, fraction := range []float64{1, -0.1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+			if _, err := NewClient("test", WithRateLimit(10, 1), WithReservedCapacity(fraction)); err == nil {
+				t.Fatalf("expected error due to invalid reserved capacity %v, got nil", fraction)
+			}
+		}
+		if _, err := NewClient("test", WithReservedCapacity(0.5)); err == nil {
+			t.Fatal("expected error due to reserved capacity without rate limit, got nil")
+		}
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithReservedCapacity(0.5),
+			WithRateLimit(50, 2),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		msgs := make([]*NewMessage, 6)
+		for i := range msgs {
+			msgs[i] = &NewMessage{Message{Topic: "news"}}
+		}
+		start := time.Now()
+		br, err := client.SendEach(WithLane(context.Background(), LaneBulk), "token", msgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if br.SuccessCount != 6 {
+			t.Fatalf("expected 6 successes, got: %+v", br)
+		}
+		// One token of the burst is reserved, the bulk sends go at the rate.
+		if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+			t.Fatalf("expected sends to be rate limited, took: %v", elapsed)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package fcm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrUnknownChannel occurs if a message references an Android notification
+	// channel which is not declared in the Registry.
+	ErrUnknownChannel = errors.New("unknown android notification channel")
+
+	// ErrUnknownCategory occurs if a message references an APNs category which
+	// is not declared in the Registry.
+	ErrUnknownCategory = errors.New("unknown apns category")
+
+	// ErrChannelBlocked occurs if a message references an Android notification
+	// channel declared with ImportanceNone, whose notifications are never
+	// shown.
+	ErrChannelBlocked = errors.New("android notification channel is blocked")
+)
+
+// ChannelImportance is the importance of an Android notification channel, the
+// values match the ones of Android's NotificationManager.
+type ChannelImportance int
+
+// Android notification channel importance levels.
+const (
+	ImportanceNone    ChannelImportance = 0
+	ImportanceMin     ChannelImportance = 1
+	ImportanceLow     ChannelImportance = 2
+	ImportanceDefault ChannelImportance = 3
+	ImportanceHigh    ChannelImportance = 4
+)
+
+// Channel is an Android notification channel declared by the application.
+type Channel struct {
+	ID         string
+	Importance ChannelImportance
+}
+
+// Registry holds the Android notification channels and APNs categories the
+// application declares, so that messages referencing them by a mistyped name
+// are rejected instead of silently falling back to the default channel.
+//
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	channels   map[string]Channel
+	categories map[string]struct{}
+	usage      map[string]int
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		channels:   make(map[string]Channel),
+		categories: make(map[string]struct{}),
+		usage:      make(map[string]int),
+	}
+}
+
+// AddChannel declares an Android notification channel.
+func (r *Registry) AddChannel(id string, importance ChannelImportance) error {
+	if id == "" {
+		return errors.New("invalid channel id")
+	}
+	if importance < ImportanceNone || importance > ImportanceHigh {
+		return errors.New("invalid channel importance")
+	}
+	r.mu.Lock()
+	r.channels[id] = Channel{ID: id, Importance: importance}
+	r.mu.Unlock()
+	return nil
+}
+
+// AddCategory declares an APNs notification category.
+func (r *Registry) AddCategory(id string) error {
+	if id == "" {
+		return errors.New("invalid category id")
+	}
+	r.mu.Lock()
+	r.categories[id] = struct{}{}
+	r.mu.Unlock()
+	return nil
+}
+
+// Channel returns the declared channel with the given id.
+func (r *Registry) Channel(id string) (Channel, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch, ok := r.channels[id]
+	return ch, ok
+}
+
+// Validate returns an error wrapping ErrUnknownChannel or ErrUnknownCategory
+// if the message references a channel or category which is not declared, or
+// ErrChannelBlocked if it references a channel with ImportanceNone.
+func (r *Registry) Validate(msg *NewMessage) error {
+	if msg == nil {
+		return ErrInvalidMessage
+	}
+
+	m := msg.Message
+	var channels []string
+	if m.Notification != nil && m.Notification.ChannelID != "" {
+		channels = append(channels, m.Notification.ChannelID)
+	}
+	if id := lookupString(m.Android, "notification", "channel_id"); id != "" {
+		channels = append(channels, id)
+	}
+	category := lookupString(m.Apns, "payload", "aps", "category")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range channels {
+		ch, ok := r.channels[id]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownChannel, id)
+		}
+		if ch.Importance == ImportanceNone {
+			return fmt.Errorf("%w: %q", ErrChannelBlocked, id)
+		}
+	}
+	if category != "" {
+		if _, ok := r.categories[category]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownCategory, category)
+		}
+	}
+	return nil
+}
+
+// record counts a message sent, see ChannelUsage.
+func (r *Registry) record(msg *NewMessage) {
+	// android.notification.channel_id takes precedence over the top-level one.
+	channel := lookupString(msg.Message.Android, "notification", "channel_id")
+	if channel == "" && msg.Message.Notification != nil {
+		channel = msg.Message.Notification.ChannelID
+	}
+
+	r.mu.Lock()
+	r.usage[channel]++
+	r.mu.Unlock()
+}
+
+// ChannelUsage returns the number of messages sent per channel id.
+// Messages that don't reference any channel are counted under the empty key,
+// they are delivered to the application's default channel.
+func (r *Registry) ChannelUsage() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage := make(map[string]int, len(r.usage))
+	for id, n := range r.usage {
+		usage[id] = n
+	}
+	return usage
+}
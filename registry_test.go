@@ -0,0 +1,137 @@
+package fcm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	newRegistry := func(t *testing.T) *Registry {
+		r := NewRegistry()
+		if err := r.AddChannel("news", ImportanceHigh); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := r.AddChannel("muted", ImportanceNone); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := r.AddCategory("MESSAGE"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return r
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		r := newRegistry(t)
+		msgs := []*NewMessage{
+			{Message{Token: "token", Notification: &Notification{ChannelID: "news"}}},
+			{Message{Token: "token", Android: map[string]interface{}{
+				"notification": map[string]interface{}{"channel_id": "news"},
+			}}},
+			{Message{Token: "token", Apns: map[string]interface{}{
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{"category": "MESSAGE"},
+				},
+			}}},
+		}
+		for _, msg := range msgs {
+			if err := r.Validate(msg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if usage := r.ChannelUsage(); len(usage) != 0 {
+			t.Fatalf("expected validation not to count usage, got: %v", usage)
+		}
+
+		for _, msg := range msgs {
+			r.record(msg)
+		}
+		usage := r.ChannelUsage()
+		if usage["news"] != 2 || usage[""] != 1 {
+			t.Fatalf("unexpected channel usage: %v", usage)
+		}
+	})
+
+	t.Run("unknown channel", func(t *testing.T) {
+		r := newRegistry(t)
+		err := r.Validate(&NewMessage{Message{Token: "token", Android: map[string]interface{}{
+			"notification": map[string]interface{}{"channel_id": "nwes"},
+		}}})
+		if !errors.Is(err, ErrUnknownChannel) {
+			t.Fatalf("expected <%v> error, got: %v", ErrUnknownChannel, err)
+		}
+		if usage := r.ChannelUsage(); len(usage) != 0 {
+			t.Fatalf("expected no usage for rejected message, got: %v", usage)
+		}
+	})
+
+	t.Run("blocked channel", func(t *testing.T) {
+		r := newRegistry(t)
+		err := r.Validate(&NewMessage{Message{Token: "token", Notification: &Notification{ChannelID: "muted"}}})
+		if !errors.Is(err, ErrChannelBlocked) {
+			t.Fatalf("expected <%v> error, got: %v", ErrChannelBlocked, err)
+		}
+	})
+
+	t.Run("unknown category", func(t *testing.T) {
+		r := newRegistry(t)
+		err := r.Validate(&NewMessage{Message{Token: "token", Apns: map[string]interface{}{
+			"payload": map[string]interface{}{
+				"aps": map[string]interface{}{"category": "MESAGE"},
+			},
+		}}})
+		if !errors.Is(err, ErrUnknownCategory) {
+			t.Fatalf("expected <%v> error, got: %v", ErrUnknownCategory, err)
+		}
+	})
+
+	t.Run("invalid declarations", func(t *testing.T) {
+		r := NewRegistry()
+		if err := r.AddChannel("", ImportanceLow); err == nil {
+			t.Fatal("expected error for empty channel id")
+		}
+		if err := r.AddChannel("news", ChannelImportance(9)); err == nil {
+			t.Fatal("expected error for invalid importance")
+		}
+		if err := r.AddCategory(""); err == nil {
+			t.Fatal("expected error for empty category")
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") == "Bearer bad" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		defer server.Close()
+
+		r := newRegistry(t)
+		c, err := NewClient("test", WithEndpoint(server.URL), WithRegistry(r))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = c.Send(&NewMessage{Message{
+			Token:        "token",
+			Notification: &Notification{ChannelID: "unknown"},
+		}}, "token")
+		if !errors.Is(err, ErrUnknownChannel) {
+			t.Fatalf("expected <%v> error, got: %v", ErrUnknownChannel, err)
+		}
+
+		msg := &NewMessage{Message{Token: "token", Notification: &Notification{ChannelID: "news"}}}
+		if _, err := c.Send(msg, "token"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := c.Send(msg, "bad"); err == nil {
+			t.Fatal("expected error due to unauthorized send, got nil")
+		}
+		if usage := r.ChannelUsage(); usage["news"] != 1 || len(usage) != 1 {
+			t.Fatalf("expected only the successful send to be counted, got: %v", usage)
+		}
+	})
+}
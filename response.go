@@ -2,7 +2,8 @@ package fcm
 
 import (
 	"encoding/json"
-	"fmt"
+	"net/http"
+	"time"
 )
 
 const (
@@ -51,38 +52,32 @@ const (
 
 const errTypeFCMError = "type.googleapis.com/google.firebase.fcm.v1.FcmError"
 
+// requestIDHeaders lists the headers which may carry a server-side request ID.
+var requestIDHeaders = []string{"X-Request-Id", "X-Cloud-Trace-Context"}
+
 // connectionError represents connection errors such as timeout error, etc.
-// Implements `net.Error` interface.
-type connectionError string
+// It wraps the error of the HTTP client, so that a canceled context can be
+// told apart. Implements `net.Error` interface.
+type connectionError struct {
+	err error
+}
 
 func (err connectionError) Error() string {
-	return string(err)
+	return err.err.Error()
 }
 
-func (err connectionError) Temporary() bool {
-	return true
+func (err connectionError) Unwrap() error {
+	return err.err
 }
 
-func (err connectionError) Timeout() bool {
+func (err connectionError) Temporary() bool {
 	return true
 }
 
-// serverError represents internal server errors.
-// Implements `net.Error` interface.
-type serverError string
-
-func (err serverError) Error() string {
-	return string(err)
-}
-
-func (serverError) Temporary() bool {
+func (err connectionError) Timeout() bool {
 	return true
 }
 
-func (serverError) Timeout() bool {
-	return false
-}
-
 type (
 	// Response represents the FCM HTTP v1 server response.
 	// On success, the response contains the "name" field (a string like "projects/myproject/messages/123").
@@ -92,6 +87,25 @@ type (
 		Name string `json:"name,omitempty"`
 		// Error response: see google.rpc.Status for details.
 		Error *ResponseError `json:"error,omitempty"`
+		// Metadata describes the HTTP exchange which produced the response.
+		Metadata ResponseMetadata `json:"-"`
+	}
+
+	// ResponseMetadata holds information about how a message was sent.
+	ResponseMetadata struct {
+		// StatusCode is the HTTP status code of the response.
+		StatusCode int
+		// Header holds the HTTP headers of the response.
+		Header http.Header
+		// RequestID is the server-side request ID, if the server returned one.
+		RequestID string
+		// Latency is the total time spent sending the message, including
+		// the backoff between retries.
+		Latency time.Duration
+		// AttemptLatencies holds the duration of every attempt made.
+		AttemptLatencies []time.Duration
+		// Attempts is the number of attempts made to send the message.
+		Attempts int
 	}
 
 	// ResponseError represents the error structure returned by the FCM HTTP v1 API.
@@ -110,6 +124,12 @@ type (
 		ErrorCode string `json:"errorCode,omitempty"`
 		// For some errors, details about which field(s) are invalid.
 		FieldViolations []ResponseErrorFieldViolation `json:"fieldViolations,omitempty"`
+		// The detail decoded according to its type: *FcmErrorDetail,
+		// *BadRequest, *QuotaFailure, *RetryInfo, *ErrorInfo, *DebugInfo or
+		// *ApnsError. It is nil for unknown types and malformed details.
+		Value interface{} `json:"-"`
+		// The raw JSON of the detail as returned by the server.
+		Raw json.RawMessage `json:"-"`
 	}
 
 	// ResponseErrorFieldViolation provides details about an individual field error.
@@ -131,7 +151,7 @@ func (r *Response) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// Err returns an error that summarizes the FCM error contained in the Response.
+// Err returns an *Error that summarizes the FCM error contained in the Response.
 // If no error is present (i.e. r.Error is nil), Err returns nil.
 //
 // The function iterates over the error details provided in r.Error.Details to extract a specific
@@ -149,7 +169,9 @@ func (r *Response) Err() error {
 
 	errCode := ErrorCodeUnspecifiedError
 	for _, detail := range r.Error.Details {
-		errCode = detail.ErrorCode
+		if detail.ErrorCode != "" {
+			errCode = detail.ErrorCode
+		}
 
 		if detail.Type == errTypeFCMError {
 			errCode = detail.ErrorCode
@@ -157,6 +179,32 @@ func (r *Response) Err() error {
 		}
 	}
 
-	return fmt.Errorf("FCM error (%s | %s): %s",
-		r.Error.Status, errCode, r.Error.Message)
+	return &Error{
+		HTTPStatus: r.Error.Code,
+		Status:     r.Error.Status,
+		Code:       errCode,
+		Message:    r.Error.Message,
+		Details:    r.Error.Details,
+		RetryAfter: r.Error.retryDelay(),
+	}
+}
+
+// retryDelay returns the delay of the RetryInfo detail, if any.
+func (e *ResponseError) retryDelay() time.Duration {
+	for _, detail := range e.Details {
+		if info, ok := detail.Value.(*RetryInfo); ok {
+			return info.RetryDelay
+		}
+	}
+	return 0
+}
+
+// requestID returns the server-side request ID found in the headers.
+func requestID(header http.Header) string {
+	for _, key := range requestIDHeaders {
+		if id := header.Get(key); id != "" {
+			return id
+		}
+	}
+	return ""
 }
@@ -2,6 +2,7 @@ package fcm
 
 import (
 	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -117,3 +118,66 @@ func TestUnmarshalMalformedResponse(t *testing.T) {
 		t.Fatal("expected error but got nil")
 	}
 }
+
+func TestResponseErrIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		target error
+	}{
+		{
+			name:   "unregistered",
+			data:   `{"error": {"code": 404, "status": "NOT_FOUND", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`,
+			target: ErrUnregistered,
+		},
+		{
+			name:   "quota exceeded",
+			data:   `{"error": {"code": 429, "status": "RESOURCE_EXHAUSTED", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"}]}}`,
+			target: ErrQuotaExceeded,
+		},
+		{
+			name:   "sender id mismatch",
+			data:   `{"error": {"code": 403, "status": "PERMISSION_DENIED", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "SENDER_ID_MISMATCH"}]}}`,
+			target: ErrSenderIDMismatch,
+		},
+		{
+			name:   "third party auth",
+			data:   `{"error": {"code": 401, "status": "UNAUTHENTICATED", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "THIRD_PARTY_AUTH_ERROR"}]}}`,
+			target: ErrThirdPartyAuth,
+		},
+		{
+			name:   "status fallback",
+			data:   `{"error": {"code": 400, "status": "INVALID_ARGUMENT"}}`,
+			target: ErrInvalidArgument,
+		},
+		{
+			name:   "unspecified",
+			data:   `{"error": {"code": 500, "status": "SOME_UNKNOWN_STATUS"}}`,
+			target: ErrUnspecified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response Response
+			if err := json.Unmarshal([]byte(tt.data), &response); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err := response.Err()
+			if !errors.Is(err, tt.target) {
+				t.Fatalf("expected %v to match %v", err, tt.target)
+			}
+			if errors.Is(err, ErrInternal) {
+				t.Fatalf("expected %v not to match %v", err, ErrInternal)
+			}
+
+			var fcmErr *Error
+			if !errors.As(err, &fcmErr) {
+				t.Fatalf("expected *Error, got: %T", err)
+			}
+			if fcmErr.HTTPStatus != response.Error.Code {
+				t.Fatalf("expected HTTP status %d, got: %d", response.Error.Code, fcmErr.HTTPStatus)
+			}
+		})
+	}
+}
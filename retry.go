@@ -1,7 +1,11 @@
 package fcm
 
 import (
-	"net"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -10,24 +14,326 @@ const (
 	maxBackoff = 1 * time.Minute
 )
 
-func retry(fn func() error, attempts int) error {
+// RetryPolicy decides whether a failed attempt is retried and how long to
+// wait before the next one. The number of attempts itself is bounded by the
+// retryAttempts argument of SendWithRetry.
+type RetryPolicy interface {
+	// Next returns the backoff before the next attempt, or false if the send
+	// must not be retried.
+	Next(state RetryState) (time.Duration, bool)
+}
+
+// RetryState describes the failed attempt a RetryPolicy decides on.
+type RetryState struct {
+	// Attempt is the number of attempts made so far, starting at 1.
+	Attempt int
+	// Elapsed is the time since the first attempt started.
+	Elapsed time.Duration
+	// Previous is the backoff chosen before this attempt, zero after the
+	// first attempt.
+	Previous time.Duration
+	// Err is the error returned by the attempt.
+	Err error
+	// Action is the classification of Err, see Classify.
+	Action Action
+	// RetryAfter is the delay the server asked for, see Error.RetryAfter, or
+	// the remaining backoff of a throttled target.
+	RetryAfter time.Duration
+}
+
+// RetryEvent describes a retry of SendWithRetry, see WithOnRetry.
+type RetryEvent struct {
+	// Target identifies the device, topic or condition the message is sent
+	// to, e.g. "token:<token>" or "topic:<topic>".
+	Target string
+	// Attempt is the number of the failed attempt, starting at 1.
+	Attempt int
+	// Err is the error returned by the failed attempt.
+	Err error
+	// Action is the classification of Err, see Classify.
+	Action Action
+	// Backoff is the delay before the next attempt.
+	Backoff time.Duration
+}
+
+// RetryOutcome describes the final result of SendWithRetry, see
+// WithOnRetryOutcome.
+type RetryOutcome struct {
+	// Target identifies the device, topic or condition the message is sent
+	// to, see RetryEvent.
+	Target string
+	// Attempts is the number of attempts made.
+	Attempts int
+	// Elapsed is the total time spent, including backoff.
+	Elapsed time.Duration
+	// Err is the final error, nil if the message was sent.
+	Err error
+}
+
+// ExponentialJitterPolicy is a RetryPolicy using exponential backoff with full
+// jitter: the backoff is a random duration between zero and
+// min(Max, Base * 2^(attempt-1)). A delay requested by the server is honored,
+// the policy gives up if it is longer than Max.
+type ExponentialJitterPolicy struct {
+	// Base is the backoff cap of the first retry, defaults to 100ms.
+	Base time.Duration
+	// Max is the upper bound of any backoff, defaults to 1m.
+	Max time.Duration
+	// MaxElapsed stops retrying once the next attempt would start later than
+	// MaxElapsed after the first one. Zero means no limit.
+	MaxElapsed time.Duration
+	// RetryOn lists the error classes which are retried, defaults to
+	// ActionRetry and ActionRetryLater.
+	RetryOn []Action
+}
+
+// Next implements RetryPolicy.
+func (p *ExponentialJitterPolicy) Next(state RetryState) (time.Duration, bool) {
+	if !retryOn(p.RetryOn, state.Action) {
+		return 0, false
+	}
+	base, max := backoffBounds(p.Base, p.Max)
+
+	ceil := base
+	for i := 1; i < state.Attempt && ceil < max; i++ {
+		ceil *= 2
+	}
+	if ceil > max {
+		ceil = max
+	}
+	backoff, ok := serverDelay(randomDuration(0, ceil), state.RetryAfter, max)
+	return backoff, ok && withinElapsed(p.MaxElapsed, state.Elapsed, backoff)
+}
+
+// DecorrelatedJitterPolicy is a RetryPolicy using decorrelated jitter: the
+// backoff is a random duration between Base and three times the previous
+// backoff, capped by Max. A delay requested by the server is honored, the
+// policy gives up if it is longer than Max.
+type DecorrelatedJitterPolicy struct {
+	// Base is the smallest backoff, defaults to 100ms.
+	Base time.Duration
+	// Max is the upper bound of any backoff, defaults to 1m.
+	Max time.Duration
+	// MaxElapsed stops retrying once the next attempt would start later than
+	// MaxElapsed after the first one. Zero means no limit.
+	MaxElapsed time.Duration
+	// RetryOn lists the error classes which are retried, defaults to
+	// ActionRetry and ActionRetryLater.
+	RetryOn []Action
+}
+
+// Next implements RetryPolicy.
+func (p *DecorrelatedJitterPolicy) Next(state RetryState) (time.Duration, bool) {
+	if !retryOn(p.RetryOn, state.Action) {
+		return 0, false
+	}
+	base, max := backoffBounds(p.Base, p.Max)
+
+	prev := state.Previous
+	if prev < base {
+		prev = base
+	}
+	ceil := 3 * prev
+	if ceil > max || ceil < prev {
+		ceil = max
+	}
+	backoff, ok := serverDelay(randomDuration(base, ceil), state.RetryAfter, max)
+	return backoff, ok && withinElapsed(p.MaxElapsed, state.Elapsed, backoff)
+}
+
+// retryAdmitter is implemented by the policies which must confirm a retry
+// once it is certain to be made, e.g. to spend a budget. A retry admitted but
+// abandoned during its backoff is refunded.
+type retryAdmitter interface {
+	admit() bool
+	refund()
+}
+
+// quadraticPolicy is the default RetryPolicy: minBackoff * attempt^2 without
+// jitter, giving up once the backoff exceeds maxBackoff.
+type quadraticPolicy struct{}
+
+func (quadraticPolicy) Next(state RetryState) (time.Duration, bool) {
+	if !retryOn(nil, state.Action) {
+		return 0, false
+	}
+	backoff := minBackoff * time.Duration(state.Attempt*state.Attempt)
+	if backoff > maxBackoff {
+		return 0, false
+	}
+	return serverDelay(backoff, state.RetryAfter, maxBackoff)
+}
+
+// retry calls fn until it succeeds, the policy gives up or attempts retries
+// were made. Backoff waits end early when ctx is done and attempts which
+// can't finish before the deadline of ctx are skipped, in both cases the
+// returned error wraps the last error of fn and the context error.
+//
+// onRetry, if not nil, is called with the failed attempt and the chosen
+// backoff right before waiting for it.
+func retry(ctx context.Context, fn func() error, attempts int, policy RetryPolicy, onRetry func(RetryState, time.Duration)) error {
+	start := time.Now()
 	var attempt int
+	var backoff time.Duration
 	for {
+		attemptStart := time.Now()
 		err := fn()
 		if err == nil {
 			return nil
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return &retryAbortedError{err: err, ctxErr: ctxErr}
+		}
 
-		if tErr, ok := err.(net.Error); !ok || !tErr.Temporary() {
+		// The breaker is open, retrying would only be rejected again.
+		if errors.Is(err, ErrCircuitOpen) {
 			return err
 		}
 
 		attempt++
-		backoff := minBackoff * time.Duration(attempt*attempt)
-		if attempt > attempts || backoff > maxBackoff {
+		if attempt > attempts {
+			return err
+		}
+
+		state := RetryState{
+			Attempt:    attempt,
+			Elapsed:    time.Since(start),
+			Previous:   backoff,
+			Err:        err,
+			Action:     Classify(err),
+			RetryAfter: retryAfterOf(err),
+		}
+		next, ok := policy.Next(state)
+		if !ok {
+			return err
+		}
+		backoff = next
+
+		// Don't wait for an attempt which is expected to outlast the deadline,
+		// judging by the duration of the last one.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff+time.Since(attemptStart) {
+			return &retryAbortedError{err: err, ctxErr: context.DeadlineExceeded}
+		}
+		admitter, admitted := policy.(retryAdmitter)
+		if admitted && !admitter.admit() {
 			return err
 		}
 
-		time.Sleep(backoff)
+		if onRetry != nil {
+			onRetry(state, backoff)
+		}
+		if ctxErr := sleep(ctx, backoff); ctxErr != nil {
+			if admitted {
+				admitter.refund()
+			}
+			return &retryAbortedError{err: err, ctxErr: ctxErr}
+		}
+	}
+}
+
+// retryAfterOf returns the delay requested by the server or, for a throttled
+// target, the remaining backoff.
+func retryAfterOf(err error) time.Duration {
+	var fcmErr *Error
+	if errors.As(err, &fcmErr) {
+		return fcmErr.RetryAfter
+	}
+	var tErr *throttledError
+	if errors.As(err, &tErr) {
+		return tErr.delay
+	}
+	return 0
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAbortedError is returned when retrying stops because of the context.
+// It unwraps to the error of the last attempt and matches the context error
+// with errors.Is.
+type retryAbortedError struct {
+	err    error
+	ctxErr error
+}
+
+func (e *retryAbortedError) Error() string {
+	return fmt.Sprintf("retry aborted: %v: %v", e.ctxErr, e.err)
+}
+
+func (e *retryAbortedError) Unwrap() error {
+	return e.err
+}
+
+func (e *retryAbortedError) Is(target error) bool {
+	return target == e.ctxErr
+}
+
+// retryOn reports whether action is one of the retried classes.
+func retryOn(classes []Action, action Action) bool {
+	if len(classes) == 0 {
+		return action == ActionRetry || action == ActionRetryLater
+	}
+	for _, c := range classes {
+		if c == action {
+			return true
+		}
+	}
+	return false
+}
+
+// serverDelay extends backoff to the delay requested by the server. It
+// reports false if the server asked to wait longer than max, retrying earlier
+// would be rejected again.
+func serverDelay(backoff, retryAfter, max time.Duration) (time.Duration, bool) {
+	if retryAfter > max {
+		return 0, false
+	}
+	if retryAfter > backoff {
+		return retryAfter, true
+	}
+	return backoff, true
+}
+
+// backoffBounds applies the defaults to the configured bounds.
+func backoffBounds(base, max time.Duration) (time.Duration, time.Duration) {
+	if base <= 0 {
+		base = minBackoff
+	}
+	if max <= 0 {
+		max = maxBackoff
+	}
+	if max < base {
+		max = base
+	}
+	return base, max
+}
+
+// withinElapsed reports whether an attempt started after backoff still
+// starts within maxElapsed.
+func withinElapsed(maxElapsed, elapsed, backoff time.Duration) bool {
+	return maxElapsed <= 0 || elapsed+backoff <= maxElapsed
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// randomDuration returns a random duration in [min, max].
+func randomDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
 	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return min + time.Duration(jitterRand.Int63n(int64(max-min)+1))
 }
@@ -1,20 +1,22 @@
 package fcm
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 )
 
 func TestRetry(t *testing.T) {
 	t.Run("retry=succcess", func(t *testing.T) {
 		var attempts int
-		err := retry(func() error {
+		err := retry(context.Background(), func() error {
 			attempts++
 			if attempts < 3 {
-				return connectionError("error")
+				return connectionError{errors.New("error")}
 			}
 			return nil
-		}, 4)
+		}, 4, quadraticPolicy{}, nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -24,20 +26,172 @@ func TestRetry(t *testing.T) {
 	})
 
 	t.Run("retry=false", func(t *testing.T) {
-		err := retry(func() error {
+		err := retry(context.Background(), func() error {
 			return errors.New("error")
-		}, 4)
+		}, 4, quadraticPolicy{}, nil)
 		if err == nil {
 			t.Fatalf("expected error: %v\ngot nil", err)
 		}
 	})
 
 	t.Run("retry=maxAttempts", func(t *testing.T) {
-		err := retry(func() error {
-			return connectionError("error")
-		}, 1)
+		err := retry(context.Background(), func() error {
+			return connectionError{errors.New("error")}
+		}, 1, quadraticPolicy{}, nil)
 		if err == nil {
 			t.Fatalf("expected error: %v\ngot nil", err)
 		}
 	})
 }
+
+func TestExponentialJitterPolicy(t *testing.T) {
+	p := &ExponentialJitterPolicy{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceil := 10 * time.Millisecond << uint(attempt-1)
+		if ceil > 50*time.Millisecond {
+			ceil = 50 * time.Millisecond
+		}
+		for i := 0; i < 20; i++ {
+			backoff, ok := p.Next(RetryState{Attempt: attempt, Action: ActionRetry})
+			if !ok {
+				t.Fatalf("attempt %d: expected retry", attempt)
+			}
+			if backoff < 0 || backoff > ceil {
+				t.Fatalf("attempt %d: backoff %v out of [0, %v]", attempt, backoff, ceil)
+			}
+		}
+	}
+
+	t.Run("max elapsed", func(t *testing.T) {
+		p := &ExponentialJitterPolicy{Base: time.Millisecond, MaxElapsed: time.Second}
+		if _, ok := p.Next(RetryState{Attempt: 1, Elapsed: time.Second, Action: ActionRetry}); ok {
+			t.Fatal("expected no retry past max elapsed time")
+		}
+	})
+
+	t.Run("error classes", func(t *testing.T) {
+		if _, ok := p.Next(RetryState{Attempt: 1, Action: ActionRetryLater}); !ok {
+			t.Fatal("expected retry for default class")
+		}
+		if _, ok := p.Next(RetryState{Attempt: 1, Action: ActionDropToken}); ok {
+			t.Fatal("expected no retry for unlisted class")
+		}
+		p := &ExponentialJitterPolicy{RetryOn: []Action{ActionRetry}}
+		if _, ok := p.Next(RetryState{Attempt: 1, Action: ActionRetry}); !ok {
+			t.Fatal("expected retry for listed class")
+		}
+		if _, ok := p.Next(RetryState{Attempt: 1, Action: ActionRetryLater}); ok {
+			t.Fatal("expected no retry for unlisted class")
+		}
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		p := &ExponentialJitterPolicy{Base: time.Millisecond, Max: time.Second}
+		backoff, ok := p.Next(RetryState{Attempt: 1, Action: ActionRetryLater, RetryAfter: 500 * time.Millisecond})
+		if !ok || backoff != 500*time.Millisecond {
+			t.Fatalf("expected server delay of 500ms, got: %v", backoff)
+		}
+		if _, ok = p.Next(RetryState{Attempt: 1, Action: ActionRetryLater, RetryAfter: time.Hour}); ok {
+			t.Fatal("expected policy to give up when the server delay exceeds its max")
+		}
+		d := &DecorrelatedJitterPolicy{Max: time.Second}
+		if _, ok = d.Next(RetryState{Attempt: 1, Action: ActionRetryLater, RetryAfter: time.Hour}); ok {
+			t.Fatal("expected policy to give up when the server delay exceeds its max")
+		}
+	})
+}
+
+func TestDecorrelatedJitterPolicy(t *testing.T) {
+	p := &DecorrelatedJitterPolicy{Base: 10 * time.Millisecond, Max: 100 * time.Millisecond}
+	var prev time.Duration
+	for attempt := 1; attempt <= 20; attempt++ {
+		backoff, ok := p.Next(RetryState{Attempt: attempt, Previous: prev, Action: ActionRetry})
+		if !ok {
+			t.Fatalf("attempt %d: expected retry", attempt)
+		}
+		ceil := 3 * prev
+		if ceil < 30*time.Millisecond {
+			ceil = 30 * time.Millisecond
+		}
+		if ceil > 100*time.Millisecond {
+			ceil = 100 * time.Millisecond
+		}
+		if backoff < 10*time.Millisecond || backoff > ceil {
+			t.Fatalf("attempt %d: backoff %v out of [10ms, %v]", attempt, backoff, ceil)
+		}
+		prev = backoff
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var attempts int
+	var states []RetryState
+	policy := retryPolicyFunc(func(state RetryState) (time.Duration, bool) {
+		states = append(states, state)
+		return time.Millisecond, true
+	})
+	err := retry(context.Background(), func() error {
+		attempts++
+		return &Error{HTTPStatus: 429, Code: ErrorCodeQuotaExceeded}
+	}, 2, policy, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got: %d", attempts)
+	}
+	if len(states) != 2 || states[1].Attempt != 2 || states[1].Previous != time.Millisecond || states[1].Action != ActionRetryLater {
+		t.Fatalf("unexpected retry states: %+v", states)
+	}
+}
+
+type retryPolicyFunc func(RetryState) (time.Duration, bool)
+
+func (f retryPolicyFunc) Next(state RetryState) (time.Duration, bool) {
+	return f(state)
+}
+
+func TestRetryContext(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		var attempts int
+		start := time.Now()
+		err := retry(ctx, func() error {
+			attempts++
+			return connectionError{errors.New("error")}
+		}, 4, retryPolicyFunc(func(RetryState) (time.Duration, bool) {
+			return 2 * time.Second, true
+		}), nil)
+		if attempts != 1 {
+			t.Fatalf("expected 1 attempt, got: %d", attempts)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected attempt past the deadline to be skipped, took: %v", elapsed)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected error to match context.DeadlineExceeded, got: %v", err)
+		}
+		var connErr connectionError
+		if !errors.As(err, &connErr) {
+			t.Fatalf("expected error to wrap the last error, got: %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var attempts int
+		err := retry(ctx, func() error {
+			attempts++
+			cancel()
+			return connectionError{errors.New("error")}
+		}, 4, quadraticPolicy{}, nil)
+		if attempts != 1 {
+			t.Fatalf("expected 1 attempt, got: %d", attempts)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error to match context.Canceled, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrMissingClient occurs if a Sender has no Client, it is the error of
+	// every SendResult.
+	ErrMissingClient = errors.New("missing client")
+	// ErrMissingAccessToken occurs if a Sender has neither an AccessToken
+	// nor a TokenSource, or the TokenSource returned an empty token.
+	ErrMissingAccessToken = errors.New("missing access token")
+)
+
+type (
+	// SendRequest is a message to send by a Sender, Key correlates it with
+	// its SendResult.
+	SendRequest struct {
+		Key     interface{}
+		Message *NewMessage
+	}
+
+	// SendResult is the result of a SendRequest.
+	SendResult struct {
+		Key      interface{}
+		Response *Response
+		Err      error
+	}
+
+	// MessageIterator provides the requests sent by Sender.RunIterator.
+	MessageIterator interface {
+		// Next returns the next request, or false once there are no more.
+		Next() (SendRequest, bool)
+	}
+)
+
+// Sender sends a stream of messages with a bounded number of sends in
+// flight, without holding the whole stream in memory.
+//
+// Results are emitted in completion order. Sends only proceed while the
+// results are drained: once Concurrency results are waiting to be received,
+// the Sender stops consuming requests.
+type Sender struct {
+	// Client sends the messages.
+	Client *Client
+	// AccessToken authorizes the sends, unless TokenSource is set.
+	AccessToken string
+	// TokenSource, if set, is called before every send to get an access
+	// token. Use it for streams outliving the lifetime of a token.
+	TokenSource func(ctx context.Context) (string, error)
+	// Concurrency is the number of sends in flight, defaults to the max
+	// concurrency of the Client. The adaptive limiter of the Client, if
+	// configured, may further bound it.
+	Concurrency int
+	// RetryAttempts is the number of retries of a failed send, see
+	// Client.SendWithRetry. Zero disables retries.
+	RetryAttempts int
+}
+
+// Run sends the requests received from in until it is closed or ctx is done,
+// and emits their results on the returned channel. The channel is closed
+// once every result was emitted. Once ctx is done, no more requests are
+// consumed and results not received yet may be dropped.
+func (s *Sender) Run(ctx context.Context, in <-chan SendRequest) <-chan SendResult {
+	workers := s.Concurrency
+	if workers <= 0 {
+		workers = DefaultMaxConcurrency
+		if s.Client != nil {
+			workers = s.Client.maxConcurrency()
+		}
+	}
+
+	out := make(chan SendResult)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var req SendRequest
+				var ok bool
+				select {
+				case req, ok = <-in:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+
+				resp, err := s.send(ctx, req.Message)
+				select {
+				case out <- SendResult{Key: req.Key, Response: resp, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// RunIterator is like Run but consumes the requests from an iterator.
+func (s *Sender) RunIterator(ctx context.Context, it MessageIterator) <-chan SendResult {
+	in := make(chan SendRequest)
+	go func() {
+		defer close(in)
+		for {
+			req, ok := it.Next()
+			if !ok {
+				return
+			}
+			select {
+			case in <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s.Run(ctx, in)
+}
+
+// send sends a single message.
+func (s *Sender) send(ctx context.Context, msg *NewMessage) (*Response, error) {
+	if s.Client == nil {
+		return nil, ErrMissingClient
+	}
+	accessToken := s.AccessToken
+	if s.TokenSource != nil {
+		var err error
+		if accessToken, err = s.TokenSource(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if accessToken == "" {
+		return nil, ErrMissingAccessToken
+	}
+
+	ctx, _ = withBatchSend(ctx)
+	if s.RetryAttempts > 0 {
+		return s.Client.SendWithRetryWithContext(ctx, msg, accessToken, s.RetryAttempts)
+	}
+	return s.Client.sendOne(ctx, accessToken, msg)
+}
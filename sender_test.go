@@ -0,0 +1,153 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type sliceIterator struct {
+	reqs []SendRequest
+}
+
+func (it *sliceIterator) Next() (SendRequest, bool) {
+	if len(it.reqs) == 0 {
+		return SendRequest{}, false
+	}
+	req := it.reqs[0]
+	it.reqs = it.reqs[1:]
+	return req, true
+}
+
+func TestSender(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		var msg NewMessage
+		if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if msg.Message.Token == "bad" {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(rw, `{"error": {"code": 400, "status": "INVALID_ARGUMENT"}}`)
+			return
+		}
+		fmt.Fprintf(rw, `{"name": "projects/test/messages/%s"}`, msg.Message.Token)
+	}))
+	defer server.Close()
+
+	client, err := NewClient("test", WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("sender=channel", func(t *testing.T) {
+		s := &Sender{Client: client, AccessToken: "token", Concurrency: 3}
+		in := make(chan SendRequest)
+		go func() {
+			defer close(in)
+			for i := 0; i < 20; i++ {
+				token := fmt.Sprintf("t%d", i)
+				if i%5 == 0 {
+					token = "bad"
+				}
+				in <- SendRequest{Key: i, Message: &NewMessage{Message{Token: token}}}
+			}
+		}()
+
+		seen := make(map[int]bool)
+		for res := range s.Run(context.Background(), in) {
+			i := res.Key.(int)
+			seen[i] = true
+			if i%5 == 0 {
+				if !errors.Is(res.Err, ErrInvalidArgument) {
+					t.Errorf("%d: expected <%v> error, got: %v", i, ErrInvalidArgument, res.Err)
+				}
+				continue
+			}
+			if res.Err != nil || res.Response.Name != fmt.Sprintf("projects/test/messages/t%d", i) {
+				t.Errorf("%d: unexpected result: %+v", i, res)
+			}
+		}
+		if len(seen) != 20 {
+			t.Fatalf("expected 20 results, got: %d", len(seen))
+		}
+	})
+
+	t.Run("sender=iterator", func(t *testing.T) {
+		it := &sliceIterator{}
+		for i := 0; i < 5; i++ {
+			it.reqs = append(it.reqs, SendRequest{Key: fmt.Sprint(i), Message: &NewMessage{Message{Topic: "news"}}})
+		}
+		var tokens int32
+		s := &Sender{Client: client, TokenSource: func(context.Context) (string, error) {
+			atomic.AddInt32(&tokens, 1)
+			return "token", nil
+		}}
+		var n int
+		for res := range s.RunIterator(context.Background(), it) {
+			if res.Err != nil {
+				t.Errorf("unexpected error: %v", res.Err)
+			}
+			n++
+		}
+		if n != 5 || atomic.LoadInt32(&tokens) != 5 {
+			t.Fatalf("expected 5 results and token fetches, got: %d, %d", n, tokens)
+		}
+	})
+
+	t.Run("sender=misconfigured", func(t *testing.T) {
+		for _, tt := range []struct {
+			sender *Sender
+			err    error
+		}{
+			{sender: &Sender{AccessToken: "token"}, err: ErrMissingClient},
+			{sender: &Sender{Client: client}, err: ErrMissingAccessToken},
+		} {
+			it := &sliceIterator{reqs: []SendRequest{{Key: 1, Message: &NewMessage{Message{Topic: "news"}}}}}
+			var n int
+			for res := range tt.sender.RunIterator(context.Background(), it) {
+				if !errors.Is(res.Err, tt.err) {
+					t.Errorf("expected <%v> error, got: %v", tt.err, res.Err)
+				}
+				n++
+			}
+			if n != 1 {
+				t.Fatalf("expected 1 result, got: %d", n)
+			}
+		}
+	})
+
+	t.Run("sender=backpressure", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		in := make(chan SendRequest, 100)
+		for i := 0; i < 100; i++ {
+			in <- SendRequest{Key: i, Message: &NewMessage{Message{Topic: "news"}}}
+		}
+		close(in)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s := &Sender{Client: client, AccessToken: "token", Concurrency: 4}
+		out := s.Run(ctx, in)
+
+		time.Sleep(100 * time.Millisecond)
+		if n := atomic.LoadInt32(&requests); n != 4 {
+			t.Fatalf("expected sends to stop while results aren't drained, got %d requests", n)
+		}
+		<-out
+		time.Sleep(50 * time.Millisecond)
+		if n := atomic.LoadInt32(&requests); n != 5 {
+			t.Fatalf("expected one more send after draining a result, got %d requests", n)
+		}
+
+		cancel()
+		for range out {
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package fcm
+
+import "fmt"
+
+const (
+	// apnsPushTypeBackground is the apns-push-type required for silent pushes.
+	apnsPushTypeBackground = "background"
+
+	// apnsPriorityBackground is the only apns-priority APNs accepts for
+	// background pushes. Priority 10 background pushes are rejected.
+	apnsPriorityBackground = "5"
+
+	// androidPriorityHigh wakes the device from Doze to deliver the message.
+	androidPriorityHigh = "high"
+)
+
+// SilentMessage describes a data-only message used to wake the application in
+// the background without showing anything to the user.
+//
+// Delivering such a message reliably needs coordinated per-platform settings
+// that are easy to get wrong by hand, use Build to get a message with all of
+// them applied.
+type SilentMessage struct {
+	Token       string
+	Topic       string
+	Condition   string
+	CollapseKey string
+	Data        map[string]interface{}
+}
+
+// Build returns a message for the silent push with android priority set to
+// high and the APNs `content-available` flag sent with `apns-push-type:
+// background` and `apns-priority: 5`. No notification fields are set.
+func (s *SilentMessage) Build() *NewMessage {
+	android := map[string]interface{}{
+		"priority": androidPriorityHigh,
+	}
+	headers := map[string]interface{}{
+		"apns-push-type": apnsPushTypeBackground,
+		"apns-priority":  apnsPriorityBackground,
+	}
+	if s.CollapseKey != "" {
+		android["collapse_key"] = s.CollapseKey
+		headers["apns-collapse-id"] = s.CollapseKey
+	}
+
+	return &NewMessage{Message: Message{
+		Token:     s.Token,
+		Topic:     s.Topic,
+		Condition: s.Condition,
+		Data:      s.Data,
+		Android:   android,
+		Apns: map[string]interface{}{
+			"headers": headers,
+			"payload": map[string]interface{}{
+				"aps": map[string]interface{}{
+					"content-available": 1,
+				},
+			},
+		},
+	}}
+}
+
+// IsSilent reports whether the message asks APNs for a background delivery,
+// either through the `content-available` flag or the `background` push type.
+func (msg *NewMessage) IsSilent() bool {
+	if msg == nil {
+		return false
+	}
+	apns := msg.Message.Apns
+	v, _ := lookup(apns, "payload", "aps", "content-available")
+	return isTruthy(v) || lookupString(apns, "headers", "apns-push-type") == apnsPushTypeBackground
+}
+
+// SilentWarnings returns human readable warnings for a silent message that
+// mixes background flags with visible notification fields or misses settings
+// required for reliable delivery. It returns nil for messages that are not
+// silent, see IsSilent.
+func (msg *NewMessage) SilentWarnings() []string {
+	if !msg.IsSilent() {
+		return nil
+	}
+
+	var warnings []string
+	m := msg.Message
+	if m.Notification != nil {
+		warnings = append(warnings, "notification is set on a silent message")
+	}
+	if _, ok := lookup(m.Android, "notification"); ok {
+		warnings = append(warnings, "android.notification is set on a silent message")
+	}
+	if _, ok := lookup(m.Webpush, "notification"); ok {
+		warnings = append(warnings, "webpush.notification is set on a silent message")
+	}
+	for _, key := range []string{"alert", "sound", "badge"} {
+		if _, ok := lookup(m.Apns, "payload", "aps", key); ok {
+			warnings = append(warnings, fmt.Sprintf("apns.payload.aps.%s is set on a silent message", key))
+		}
+	}
+
+	if v, _ := lookup(m.Apns, "payload", "aps", "content-available"); !isTruthy(v) {
+		warnings = append(warnings, "apns.payload.aps.content-available is not set")
+	}
+	if pushType := lookupString(m.Apns, "headers", "apns-push-type"); pushType != apnsPushTypeBackground {
+		warnings = append(warnings, fmt.Sprintf("apns-push-type is %q, expected %q", pushType, apnsPushTypeBackground))
+	}
+	if priority := lookupString(m.Apns, "headers", "apns-priority"); priority != apnsPriorityBackground {
+		warnings = append(warnings, fmt.Sprintf("apns-priority is %q, expected %q", priority, apnsPriorityBackground))
+	}
+	if priority := lookupString(m.Android, "priority"); priority != androidPriorityHigh {
+		warnings = append(warnings, fmt.Sprintf("android.priority is %q, expected %q", priority, androidPriorityHigh))
+	}
+	if m.ContentAvailable {
+		warnings = append(warnings, "content_available is ignored by the HTTP v1 API, set it in apns.payload.aps instead")
+	}
+	return warnings
+}
+
+// isTruthy reports whether a decoded JSON flag is set.
+func isTruthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != "" && v != "0"
+	}
+	return false
+}
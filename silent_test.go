@@ -0,0 +1,82 @@
+package fcm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSilentMessage(t *testing.T) {
+	t.Run("build", func(t *testing.T) {
+		msg := (&SilentMessage{
+			Token:       "token",
+			CollapseKey: "sync",
+			Data:        map[string]interface{}{"sync": "1"},
+		}).Build()
+		if err := msg.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !msg.IsSilent() {
+			t.Fatal("expected message to be silent")
+		}
+		if warnings := msg.SilentWarnings(); len(warnings) != 0 {
+			t.Fatalf("expected no warnings, got: %v", warnings)
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, want := range []string{
+			`"priority":"high"`,
+			`"apns-push-type":"background"`,
+			`"apns-priority":"5"`,
+			`"content-available":1`,
+			`"apns-collapse-id":"sync"`,
+		} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("expected %s in %s", want, data)
+			}
+		}
+	})
+
+	t.Run("mixed with notification", func(t *testing.T) {
+		msg := (&SilentMessage{Topic: "news"}).Build()
+		msg.Message.Notification = &Notification{Title: "title"}
+		msg.Message.Apns["payload"].(map[string]interface{})["aps"].(map[string]interface{})["alert"] = "hi"
+
+		warnings := msg.SilentWarnings()
+		if len(warnings) != 2 {
+			t.Fatalf("expected 2 warnings, got: %v", warnings)
+		}
+	})
+
+	t.Run("wrong headers", func(t *testing.T) {
+		msg := &NewMessage{Message{
+			Token: "token",
+			Apns: map[string]interface{}{
+				"headers": map[string]string{"apns-priority": "10"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{"content-available": true},
+				},
+			},
+		}}
+		warnings := msg.SilentWarnings()
+		if len(warnings) != 3 {
+			t.Fatalf("expected 3 warnings, got: %v", warnings)
+		}
+	})
+
+	t.Run("not silent", func(t *testing.T) {
+		msg := &NewMessage{Message{
+			Token:        "token",
+			Notification: &Notification{Title: "title"},
+		}}
+		if msg.IsSilent() {
+			t.Fatal("expected message not to be silent")
+		}
+		if warnings := msg.SilentWarnings(); warnings != nil {
+			t.Fatalf("expected no warnings, got: %v", warnings)
+		}
+	})
+}
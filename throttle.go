@@ -0,0 +1,217 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTargetThrottled occurs if a send is rejected because its target
+// recently exceeded its quota and is still backed off.
+var ErrTargetThrottled = errors.New("target is throttled")
+
+const (
+	defaultTargetBackoffInitial    = 1 * time.Second
+	defaultTargetBackoffMax        = 5 * time.Minute
+	defaultTargetBackoffMultiplier = 2
+
+	// targetSweepInterval is how often expired target states are removed.
+	targetSweepInterval = 1 * time.Minute
+)
+
+// TargetBackoffConfig configures the per-target backoff applied after FCM
+// returns QUOTA_EXCEEDED for a device or topic, zero values are replaced by
+// defaults. A QUOTA_EXCEEDED whose QuotaFailure detail names the project as
+// subject is a project quota and doesn't back off the target.
+type TargetBackoffConfig struct {
+	// Initial is the backoff after the first QUOTA_EXCEEDED, defaults to 1s.
+	Initial time.Duration
+	// Max is the upper bound of the backoff, defaults to 5m.
+	Max time.Duration
+	// Multiplier is the growth of the backoff on every further
+	// QUOTA_EXCEEDED, defaults to 2.
+	Multiplier float64
+	// MaxWait is how long a send to a backed off target waits for the
+	// backoff to end, longer backoffs reject the send with
+	// ErrTargetThrottled. Zero rejects right away.
+	MaxWait time.Duration
+	// ResetAfter is how long after its backoff ended the state of a target
+	// is forgotten, so that the next backoff starts again from Initial.
+	// Defaults to Max.
+	ResetAfter time.Duration
+}
+
+// targetThrottle tracks the backoff state of the targets which exceeded
+// their quota.
+type targetThrottle struct {
+	mu        sync.Mutex
+	cfg       TargetBackoffConfig
+	targets   map[string]*targetState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type targetState struct {
+	backoff time.Duration
+	until   time.Time
+}
+
+func newTargetThrottle(cfg TargetBackoffConfig) *targetThrottle {
+	if cfg.Initial <= 0 {
+		cfg.Initial = defaultTargetBackoffInitial
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = defaultTargetBackoffMax
+	}
+	if cfg.Max < cfg.Initial {
+		cfg.Max = cfg.Initial
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = defaultTargetBackoffMultiplier
+	}
+	if cfg.ResetAfter <= 0 {
+		cfg.ResetAfter = cfg.Max
+	}
+	return &targetThrottle{
+		cfg:     cfg,
+		targets: make(map[string]*targetState),
+		now:     time.Now,
+	}
+}
+
+// wait blocks until the backoff of target ended, or returns an error
+// wrapping ErrTargetThrottled if it lasts longer than MaxWait.
+func (t *targetThrottle) wait(ctx context.Context, target string) error {
+	t.mu.Lock()
+	var delay time.Duration
+	if state, ok := t.targets[target]; ok {
+		delay = state.until.Sub(t.now())
+	}
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if delay > t.cfg.MaxWait {
+		return &throttledError{target: target, delay: delay}
+	}
+	return sleep(ctx, delay)
+}
+
+// record updates the backoff state of target after a send. Exceeding the
+// quota of the whole project says nothing about target and is ignored.
+func (t *targetThrottle) record(target string, err error) {
+	if err != nil && (!errors.Is(err, ErrQuotaExceeded) || isProjectQuota(err)) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+	if err == nil {
+		delete(t.targets, target)
+		return
+	}
+
+	state, ok := t.targets[target]
+	switch {
+	case !ok || now.Sub(state.until) > t.cfg.ResetAfter:
+		state = &targetState{backoff: t.cfg.Initial}
+		t.targets[target] = state
+	case now.Before(state.until):
+		// Sends which were already in flight when the target got backed
+		// off don't grow the backoff further.
+		return
+	default:
+		state.backoff = time.Duration(float64(state.backoff) * t.cfg.Multiplier)
+		if state.backoff > t.cfg.Max {
+			state.backoff = t.cfg.Max
+		}
+	}
+
+	backoff := state.backoff
+	var fcmErr *Error
+	if errors.As(err, &fcmErr) && fcmErr.RetryAfter > backoff {
+		backoff = fcmErr.RetryAfter
+	}
+	state.until = now.Add(backoff)
+}
+
+// sweep removes the expired states, at most once per targetSweepInterval.
+func (t *targetThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < targetSweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for target, state := range t.targets {
+		if now.Sub(state.until) > t.cfg.ResetAfter {
+			delete(t.targets, target)
+		}
+	}
+}
+
+// isProjectQuota reports whether err is a QUOTA_EXCEEDED for the quota of the
+// project, according to the subjects of its QuotaFailure violations, rather
+// than the one of a device or topic.
+func isProjectQuota(err error) bool {
+	var fcmErr *Error
+	if !errors.As(err, &fcmErr) {
+		return false
+	}
+	for _, d := range fcmErr.Details {
+		qf, ok := d.Value.(*QuotaFailure)
+		if !ok {
+			continue
+		}
+		for _, v := range qf.Violations {
+			if strings.HasPrefix(v.Subject, "project:") || strings.HasPrefix(v.Subject, "projects/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// len returns the number of tracked targets.
+func (t *targetThrottle) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.targets)
+}
+
+// throttledError is returned for sends to a backed off target.
+type throttledError struct {
+	target string
+	delay  time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("%v: %s for %v", ErrTargetThrottled, e.target, e.delay)
+}
+
+func (e *throttledError) Is(target error) bool {
+	return target == ErrTargetThrottled
+}
+
+// targetKey returns the key the backoff state of the message target is
+// tracked by.
+func targetKey(msg *NewMessage) string {
+	switch m := msg.Message; {
+	case m.Token != "":
+		return tokenTarget(m.Token)
+	case m.Topic != "":
+		return "topic:" + strings.TrimPrefix(m.Topic, "/topics/")
+	default:
+		return "condition:" + m.Condition
+	}
+}
+
+// tokenTarget returns the target key of a registration token.
+func tokenTarget(token string) string {
+	return "token:" + token
+}
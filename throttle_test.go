@@ -0,0 +1,166 @@
+package fcm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTargetThrottle(t *testing.T) {
+	quotaErr := &Error{HTTPStatus: http.StatusTooManyRequests, Code: ErrorCodeQuotaExceeded}
+	newThrottle := func(cfg TargetBackoffConfig) (*targetThrottle, *time.Time) {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		th := newTargetThrottle(cfg)
+		th.now = func() time.Time { return now }
+		return th, &now
+	}
+	ctx := context.Background()
+
+	t.Run("exponential growth", func(t *testing.T) {
+		th, now := newThrottle(TargetBackoffConfig{Initial: time.Second, Max: 3 * time.Second})
+		expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+		for _, backoff := range expected {
+			th.record("token:a", quotaErr)
+
+			err := th.wait(ctx, "token:a")
+			var tErr *throttledError
+			if !errors.As(err, &tErr) || tErr.delay != backoff {
+				t.Fatalf("expected backoff of %v, got: %v", backoff, err)
+			}
+			if !errors.Is(err, ErrTargetThrottled) {
+				t.Fatalf("expected <%v> error, got: %v", ErrTargetThrottled, err)
+			}
+			if err := th.wait(ctx, "token:b"); err != nil {
+				t.Fatalf("expected other targets not to be throttled, got: %v", err)
+			}
+			*now = now.Add(backoff)
+		}
+	})
+
+	t.Run("in flight", func(t *testing.T) {
+		th, _ := newThrottle(TargetBackoffConfig{Initial: time.Second})
+		th.record("topic:news", quotaErr)
+		th.record("topic:news", quotaErr)
+		var tErr *throttledError
+		if err := th.wait(ctx, "topic:news"); !errors.As(err, &tErr) || tErr.delay != time.Second {
+			t.Fatalf("expected concurrent failures not to grow the backoff, got: %v", err)
+		}
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		th, _ := newThrottle(TargetBackoffConfig{Initial: time.Second})
+		th.record("token:a", &Error{HTTPStatus: http.StatusTooManyRequests, Code: ErrorCodeQuotaExceeded, RetryAfter: time.Minute})
+		var tErr *throttledError
+		if err := th.wait(ctx, "token:a"); !errors.As(err, &tErr) || tErr.delay != time.Minute {
+			t.Fatalf("expected server delay to be honored, got: %v", err)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		th, now := newThrottle(TargetBackoffConfig{Initial: time.Second, ResetAfter: time.Minute})
+		th.record("token:a", quotaErr)
+		th.record("token:b", quotaErr)
+		th.record("token:c", &Error{HTTPStatus: http.StatusNotFound, Code: ErrorCodeUnregistered})
+		if th.len() != 2 {
+			t.Fatalf("expected 2 tracked targets, got: %d", th.len())
+		}
+
+		th.record("token:b", nil)
+		if th.len() != 1 {
+			t.Fatalf("expected success to clear the target, got: %d", th.len())
+		}
+
+		*now = now.Add(2 * time.Minute)
+		th.record("token:d", nil)
+		if th.len() != 0 {
+			t.Fatalf("expected expired targets to be removed, got: %d", th.len())
+		}
+	})
+
+	t.Run("project quota", func(t *testing.T) {
+		var response Response
+		data := []byte(`{"error": {"code": 429, "status": "RESOURCE_EXHAUSTED", "details": [
+			{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"},
+			{"@type": "type.googleapis.com/google.rpc.QuotaFailure", "violations": [{"subject": "project:1234", "description": "Message rate exceeded"}]}
+		]}}`)
+		if err := json.Unmarshal(data, &response); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		th, _ := newThrottle(TargetBackoffConfig{Initial: time.Second})
+		th.record("token:a", response.Err())
+		if th.len() != 0 {
+			t.Fatal("expected project quota not to back off the target")
+		}
+	})
+
+	t.Run("max wait", func(t *testing.T) {
+		th := newTargetThrottle(TargetBackoffConfig{Initial: 20 * time.Millisecond, MaxWait: time.Second})
+		th.record("token:a", quotaErr)
+		start := time.Now()
+		if err := th.wait(ctx, "token:a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+			t.Fatalf("expected send to wait for the backoff, took: %v", elapsed)
+		}
+	})
+
+	t.Run("client", func(t *testing.T) {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requests++
+			rw.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(rw, `{"error": {"code": 429, "status": "RESOURCE_EXHAUSTED", "details": [
+				{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"}
+			]}}`)
+		}))
+		defer server.Close()
+
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithTargetBackoff(TargetBackoffConfig{Initial: time.Hour}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msg := &NewMessage{Message{Token: "device"}}
+		if _, err := client.Send(msg, "token"); !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("expected <%v> error, got: %v", ErrQuotaExceeded, err)
+		}
+		_, err = client.Send(msg, "token")
+		if !errors.Is(err, ErrTargetThrottled) {
+			t.Fatalf("expected <%v> error, got: %v", ErrTargetThrottled, err)
+		}
+		if Classify(err) != ActionRetryLater {
+			t.Fatalf("expected %v, got: %v", ActionRetryLater, Classify(err))
+		}
+		if _, err := client.Send(&NewMessage{Message{Token: "other"}}, "token"); !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("expected other device to be sent, got: %v", err)
+		}
+		if requests != 2 {
+			t.Fatalf("expected 2 requests, got: %d", requests)
+		}
+	})
+}
+
+func TestTargetKey(t *testing.T) {
+	tests := []struct {
+		msg      Message
+		expected string
+	}{
+		{Message{Token: "abc"}, "token:abc"},
+		{Message{Topic: "news"}, "topic:news"},
+		{Message{Topic: "/topics/news"}, "topic:news"},
+		{Message{Condition: "'a' in topics"}, "condition:'a' in topics"},
+	}
+	for _, tt := range tests {
+		if got := targetKey(&NewMessage{tt.msg}); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package fcm
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 100
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultPingInterval        = 30 * time.Second
+	defaultPingTimeout         = 15 * time.Second
+	defaultTLSSessionCacheSize = 64
+)
+
+// TransportConfig configures the HTTP transport built by NewClient, zero
+// values are replaced by defaults.
+//
+// HTTP/2 is always attempted, plain HTTP endpoints fall back to HTTP/1.1.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept open to
+	// the endpoint, defaults to 100.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the number of connections to the endpoint,
+	// zero means no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open, defaults
+	// to 90s.
+	IdleConnTimeout time.Duration
+	// TLSHandshakeTimeout defaults to 10s.
+	TLSHandshakeTimeout time.Duration
+	// PingInterval is how long an HTTP/2 connection may stay silent before
+	// a health ping is sent, defaults to 30s. Connections not answering
+	// within PingTimeout, defaults to 15s, are closed. Pings require Go 1.24
+	// or later and are disabled on older versions.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	// TLSSessionCacheSize is the number of TLS sessions cached to resume
+	// handshakes when reconnecting, defaults to 64.
+	TLSSessionCacheSize int
+	// TLSClientConfig is the base TLS configuration, e.g. to set RootCAs.
+	// It is cloned and a session cache is added unless it has one.
+	TLSClientConfig *tls.Config
+}
+
+// newTransport builds the HTTP transport tuned for FCM.
+func newTransport(cfg TransportConfig) *http.Transport {
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if cfg.TLSHandshakeTimeout <= 0 {
+		cfg.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = defaultPingInterval
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = defaultPingTimeout
+	}
+	if cfg.TLSSessionCacheSize <= 0 {
+		cfg.TLSSessionCacheSize = defaultTLSSessionCacheSize
+	}
+
+	tlsConfig := &tls.Config{}
+	if cfg.TLSClientConfig != nil {
+		tlsConfig = cfg.TLSClientConfig.Clone()
+	}
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(cfg.TLSSessionCacheSize)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   defaultDialTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        cfg.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		TLSClientConfig:     tlsConfig,
+	}
+	configureHTTP2(t, cfg)
+	return t
+}
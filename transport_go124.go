@@ -0,0 +1,14 @@
+//go:build go1.24
+// +build go1.24
+
+package fcm
+
+import "net/http"
+
+// configureHTTP2 enables the health pings of HTTP/2 connections.
+func configureHTTP2(t *http.Transport, cfg TransportConfig) {
+	t.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: cfg.PingInterval,
+		PingTimeout:     cfg.PingTimeout,
+	}
+}
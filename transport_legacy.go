@@ -0,0 +1,10 @@
+//go:build !go1.24
+// +build !go1.24
+
+package fcm
+
+import "net/http"
+
+// configureHTTP2 does nothing, the standard library only supports HTTP/2
+// health pings since Go 1.24.
+func configureHTTP2(t *http.Transport, cfg TransportConfig) {}
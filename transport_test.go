@@ -0,0 +1,149 @@
+package fcm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHTTP2Server starts a local HTTP/2 TLS server answering every send. The
+// caller must close it.
+func newHTTP2Server() (*httptest.Server, *tls.Config) {
+	return newTLSServer([]string{"h2"})
+}
+
+// newHTTP1Server starts a local HTTP/1.1 TLS server answering every send.
+// The caller must close it.
+func newHTTP1Server() (*httptest.Server, *tls.Config) {
+	return newTLSServer([]string{"http/1.1"})
+}
+
+func newTLSServer(protos []string) (*httptest.Server, *tls.Config) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(rw, `{"name": "projects/test/messages/%d"}`, req.ProtoMajor)
+	}))
+	server.TLS = &tls.Config{NextProtos: protos}
+	server.StartTLS()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return server, &tls.Config{RootCAs: pool}
+}
+
+func TestTransport(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		tr := newTransport(TransportConfig{})
+		if !tr.ForceAttemptHTTP2 {
+			t.Fatal("expected HTTP/2 to be attempted")
+		}
+		if tr.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost || tr.IdleConnTimeout != defaultIdleConnTimeout {
+			t.Fatalf("unexpected idle pool: %d, %v", tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+		}
+		if tr.TLSClientConfig.ClientSessionCache == nil {
+			t.Fatal("expected TLS session cache")
+		}
+	})
+
+	t.Run("tls config", func(t *testing.T) {
+		base := &tls.Config{ServerName: "fcm.example.com"}
+		tr := newTransport(TransportConfig{TLSClientConfig: base})
+		if tr.TLSClientConfig == base || tr.TLSClientConfig.ServerName != base.ServerName {
+			t.Fatal("expected base TLS config to be cloned")
+		}
+		if base.ClientSessionCache != nil {
+			t.Fatal("expected base TLS config to be left untouched")
+		}
+	})
+
+	t.Run("http2", func(t *testing.T) {
+		server, tlsConfig := newHTTP2Server()
+		defer server.Close()
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithTransport(TransportConfig{TLSClientConfig: tlsConfig}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp, err := client.SendWithContext(context.Background(), "token", &NewMessage{Message{Topic: "news"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Name != "projects/test/messages/2" {
+			t.Fatalf("expected send over HTTP/2, got: %s", resp.Name)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewClient("test", WithTransport(TransportConfig{MaxConnsPerHost: -1})); err == nil {
+			t.Fatal("expected error due to invalid transport config, got nil")
+		}
+		if _, err := NewClient("test", WithHTTPClient(http.DefaultClient), WithTransport(TransportConfig{})); err == nil {
+			t.Fatal("expected error due to both http client and transport, got nil")
+		}
+	})
+}
+
+// BenchmarkTransport compares the tuned transport with the transport of a
+// zero http.Client, which keeps 2 idle connections per host, under parallel
+// sends. Over HTTP/1.1, the default transport closes the connections it
+// can't keep idle and dials new ones, reported as dials/op. Over HTTP/2, both
+// multiplex the sends over a single connection and perform alike.
+func BenchmarkTransport(b *testing.B) {
+	msg := &NewMessage{Message{Topic: "news"}}
+	servers := map[string]func() (*httptest.Server, *tls.Config){
+		"http1": newHTTP1Server,
+		"http2": newHTTP2Server,
+	}
+	for _, proto := range []string{"http1", "http2"} {
+		b.Run(proto, func(b *testing.B) {
+			server, tlsConfig := servers[proto]()
+			defer server.Close()
+
+			defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
+			defaultTransport.TLSClientConfig = tlsConfig
+			transports := map[string]*http.Transport{
+				"default": defaultTransport,
+				"tuned":   newTransport(TransportConfig{TLSClientConfig: tlsConfig}),
+			}
+			for _, name := range []string{"default", "tuned"} {
+				b.Run(name, func(b *testing.B) {
+					var dials int64
+					tr := transports[name].Clone()
+					dial := tr.DialContext
+					tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+						atomic.AddInt64(&dials, 1)
+						return dial(ctx, network, addr)
+					}
+					defer tr.CloseIdleConnections()
+					client, err := NewClient("test",
+						WithEndpoint(server.URL),
+						WithHTTPClient(&http.Client{Transport: tr, Timeout: 10 * time.Second}),
+					)
+					if err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+					b.ReportAllocs()
+					b.SetParallelism(16)
+					b.ResetTimer()
+					b.RunParallel(func(pb *testing.PB) {
+						for pb.Next() {
+							if _, err := client.SendWithContext(context.Background(), "token", msg); err != nil {
+								b.Errorf("unexpected error: %v", err)
+								return
+							}
+						}
+					})
+					b.ReportMetric(float64(atomic.LoadInt64(&dials))/float64(b.N), "dials/op")
+				})
+			}
+		})
+	}
+}
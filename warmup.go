@@ -0,0 +1,119 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// Warmup establishes up to n connections to the endpoint ahead of a large
+// send, so that it starts at full throughput instead of paying for the TLS
+// handshakes on the first messages. It returns the number of connections
+// ready in the pool.
+//
+// HTTP/2 multiplexes requests over a single connection, so Warmup then
+// returns 1. With HTTP/1.1, n is bounded by the number of idle connections
+// the transport keeps per host. Each connection is bounded by the client
+// timeout. A non-nil error is returned if some connections could not be
+// established.
+func (c *Client) Warmup(ctx context.Context, n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("invalid number of connections")
+	}
+	if max := c.maxIdleConns(); n > max {
+		n = max
+	}
+
+	var (
+		mu       sync.Mutex
+		conns    = make(map[net.Conn]struct{})
+		firstErr error
+		arrived  sync.WaitGroup
+		wg       sync.WaitGroup
+	)
+	ready := make(chan struct{})
+	arrived.Add(n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			var once sync.Once
+			arrive := func() { once.Do(arrived.Done) }
+			defer arrive()
+
+			var conn net.Conn
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					conn = info.Conn
+					// Hold the connection until every request got one, so
+					// that the others dial their own.
+					arrive()
+					select {
+					case <-ready:
+					case <-ctx.Done():
+					}
+				},
+			}
+			err := c.ping(httptrace.WithClientTrace(ctx, trace))
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			conns[conn] = struct{}{}
+		}()
+	}
+	arrived.Wait()
+	close(ready)
+	wg.Wait()
+
+	return len(conns), firstErr
+}
+
+// ping sends a HEAD request to the endpoint, only to get a connection. The
+// response status doesn't matter.
+func (c *Client) ping(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodHead, c.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return connectionError{err}
+	}
+	// Drain the body so that the connection returns to the pool.
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// maxIdleConns returns the number of idle connections per host kept by the
+// transport of the client, bounded by its connection limit.
+func (c *Client) maxIdleConns() int {
+	rt := c.client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return defaultMaxIdleConnsPerHost
+	}
+	max := t.MaxIdleConnsPerHost
+	if max <= 0 {
+		max = http.DefaultMaxIdleConnsPerHost
+	}
+	if t.MaxConnsPerHost > 0 && t.MaxConnsPerHost < max {
+		max = t.MaxConnsPerHost
+	}
+	return max
+}
@@ -0,0 +1,132 @@
+package fcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	t.Run("http1", func(t *testing.T) {
+		var conns int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(rw, `{"name": "projects/test/messages/1"}`)
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := client.Warmup(context.Background(), 0); err == nil {
+			t.Fatal("expected error due to invalid number of connections, got nil")
+		}
+		n, err := client.Warmup(context.Background(), 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 5 || atomic.LoadInt32(&conns) != 5 {
+			t.Fatalf("expected 5 connections, got: %d ready, %d opened", n, conns)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := client.SendWithContext(context.Background(), "token", &NewMessage{Message{Topic: "news"}}); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if c := atomic.LoadInt32(&conns); c != 5 {
+			t.Fatalf("expected sends to reuse the warm connections, got %d opened", c)
+		}
+	})
+
+	t.Run("http2", func(t *testing.T) {
+		server, tlsConfig := newHTTP2Server()
+		defer server.Close()
+		client, err := NewClient("test",
+			WithEndpoint(server.URL),
+			WithTransport(TransportConfig{TLSClientConfig: tlsConfig}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n, err := client.Warmup(context.Background(), 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 1 {
+			t.Fatalf("expected a single multiplexed connection, got: %d", n)
+		}
+	})
+
+	t.Run("idle limit", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+		defer server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL), WithHTTPClient(&http.Client{}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n, err := client.Warmup(context.Background(), 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != http.DefaultMaxIdleConnsPerHost {
+			t.Fatalf("expected %d connections, got: %d", http.DefaultMaxIdleConnsPerHost, n)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			<-done
+		}))
+		defer server.Close()
+		defer close(done)
+
+		client, err := NewClient("test", WithEndpoint(server.URL), WithTimeout(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		start := time.Now()
+		n, err := client.Warmup(context.Background(), 3)
+		if n != 0 || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected no connection and a timeout, got: %d, %v", n, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected pings to time out, took: %v", elapsed)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+		server.Close()
+
+		client, err := NewClient("test", WithEndpoint(server.URL))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n, err := client.Warmup(context.Background(), 3)
+		if n != 0 || err == nil {
+			t.Fatalf("expected no connection and an error, got: %d, %v", n, err)
+		}
+	})
+}